@@ -0,0 +1,143 @@
+package admin
+
+import (
+	"context"
+	"net"
+
+	dockerClient "github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projecteru2/minions/barrel"
+	calDriver "github.com/projecteru2/minions/driver/calico"
+	calIpamDriver "github.com/projecteru2/minions/driver/calico/ipam"
+	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
+	"github.com/projecteru2/minions/types"
+)
+
+// Admin manages reserved addresses on behalf of operators
+type Admin struct {
+	calicoIPAM   *calIpamDriver.CalicoIPAM
+	calNetDriver calNetDriver.Driver
+	dockerCli    *dockerClient.Client
+	meta         barrel.Meta
+}
+
+// NewAdmin .
+func NewAdmin(cliv3 clientv3.Interface, pools *calDriver.PoolCache, dockerCli *dockerClient.Client, meta barrel.Meta) (*Admin, error) {
+	calNet, err := calNetDriver.NewNetworkDriver(cliv3, dockerCli, pools)
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{
+		calicoIPAM:   calIpamDriver.NewCalicoIPAM(cliv3, pools),
+		calNetDriver: calNet,
+		dockerCli:    dockerCli,
+		meta:         meta,
+	}, nil
+}
+
+// ListReservedAddresses lists reserved addresses of the pool, lists all pools when poolID is blank
+func (a *Admin) ListReservedAddresses(ctx context.Context, poolID string) ([]types.ReservedAddress, error) {
+	return a.meta.ListReservedAddresses(ctx, poolID)
+}
+
+// GetReservedAddress fills address with the reservation, returns false when address is not reserved
+func (a *Admin) GetReservedAddress(ctx context.Context, address *types.ReservedAddress) (bool, error) {
+	return a.meta.IPIsReserved(ctx, address)
+}
+
+// ReleaseReservedAddress removes the reservation and gives the address back to calico,
+// returns false when address is not reserved
+func (a *Admin) ReleaseReservedAddress(ctx context.Context, address *types.ReservedAddress) (bool, error) {
+	released, err := a.meta.ReleaseReservedAddress(ctx, address)
+	if err != nil || !released {
+		return released, err
+	}
+	log.Infof("[Admin::ReleaseReservedAddress] reservation of ip(%s) in pool(%s) removed, releasing to calico", address.Address, address.PoolID)
+	return true, a.calicoIPAM.ReleaseIP(ctx, address.PoolID, address.Address)
+}
+
+// ReserveAddress assigns the address in calico and reserves it for the container identity,
+// the address must be free and inside the pool, it's filled with the container once reserved
+func (a *Admin) ReserveAddress(ctx context.Context, address *types.ReservedAddress, containerID string) error {
+	ip := net.ParseIP(address.Address)
+	if ip == nil {
+		return errors.Errorf("invalid ip %q", address.Address)
+	}
+	if address.PoolID == "" {
+		return errors.New("pool of the reserved address is required")
+	}
+	if containerID == "" {
+		return errors.New("container of the reserved address is required")
+	}
+	pool, err := a.calicoIPAM.GetIPPool(ctx, address.PoolID)
+	if err != nil {
+		return err
+	}
+	_, cidr, err := net.ParseCIDR(pool.Spec.CIDR)
+	if err != nil {
+		return err
+	}
+	if !cidr.Contains(ip) {
+		return types.ErrCIDRNotInPool
+	}
+	// calico refuses addresses already assigned, so ips in use can't be reserved
+	if _, err = a.calicoIPAM.AssignIP(ctx, address.Address); err != nil {
+		return err
+	}
+	if err = a.meta.ReserveIPforContainer(ctx, address, containerID); err != nil {
+		log.Errorf("[Admin::ReserveAddress] reserve ip(%s) in pool(%s) error, releasing to calico, %v", address.Address, address.PoolID, err)
+		if releaseErr := a.calicoIPAM.ReleaseIP(ctx, address.PoolID, address.Address); releaseErr != nil {
+			log.Errorf("[Admin::ReserveAddress] release ip(%s) to calico error, %v", address.Address, releaseErr)
+		}
+		return err
+	}
+	address.ContainerID = containerID
+	return nil
+}
+
+// GetContainerInfo returns addresses reserved by the container identity, returns false when there is none.
+// Stale addresses which are no longer reserved are skipped, they are reported by Reconcile
+func (a *Admin) GetContainerInfo(ctx context.Context, containerID string) (*types.ContainerInfo, bool, error) {
+	info := &types.ContainerInfo{ID: containerID}
+	found, err := a.meta.GetContainerInfo(ctx, info)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	addresses := make([]types.ReservedAddress, 0, len(info.Addresses))
+	for _, address := range info.Addresses {
+		reserved, err := a.meta.IPIsReserved(ctx, &address)
+		if err != nil {
+			return nil, false, err
+		}
+		if reserved && address.ContainerID == containerID {
+			addresses = append(addresses, address)
+		}
+	}
+	info.Addresses = addresses
+	return info, true, nil
+}
+
+// MarkReserveRequest marks the address to be reserved when its container leaves,
+// so a running container keeps its ip without the fixed-ip label
+func (a *Admin) MarkReserveRequest(ctx context.Context, request *types.ReserveRequest) error {
+	if net.ParseIP(request.Address) == nil {
+		return errors.Errorf("invalid ip %q", request.Address)
+	}
+	if request.PoolID == "" {
+		return errors.New("pool of the request mark is required")
+	}
+	return a.meta.MarkReserveRequest(ctx, request)
+}
+
+// ListReserveRequests lists request marks of the pool, lists all pools when poolID is blank
+func (a *Admin) ListReserveRequests(ctx context.Context, poolID string) ([]types.ReserveRequest, error) {
+	return a.meta.ListReserveRequests(ctx, poolID)
+}
+
+// CancelReserveRequest removes the request mark, returns false when address is not marked
+func (a *Admin) CancelReserveRequest(ctx context.Context, request *types.ReserveRequest) (bool, error) {
+	return a.meta.ConsumeRequestMarkIfPresent(ctx, request)
+}
@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel/memory"
+	"github.com/projecteru2/minions/types"
+)
+
+func TestGetContainerInfo(t *testing.T) {
+	ctx := context.Background()
+	meta := memory.NewMemory()
+	a := &Admin{meta: meta}
+
+	_, found, err := a.GetContainerInfo(ctx, "c1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		require.NoError(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: "pool", Address: ip}, "c1"))
+	}
+	// reserved again for another container, the record of c1 still lists it
+	require.NoError(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.2"}, "c2"))
+
+	info, found, err := a.GetContainerInfo(ctx, "c1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Len(t, info.Addresses, 1)
+	assert.Equal(t, "10.0.0.1", info.Addresses[0].Address)
+	assert.Equal(t, "c1", info.Addresses[0].ContainerID)
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+
+	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
+)
+
+// Pool is a calico pool and the docker networks it serves
+type Pool struct {
+	Name       string
+	CIDR       string
+	NetworkIDs []string
+}
+
+// Endpoint is a calico workload endpoint of a docker endpoint on this host
+type Endpoint struct {
+	Name          string
+	EndpointID    string
+	InterfaceName string
+	MAC           string
+	IPNetworks    []string
+	Profiles      []string
+}
+
+// ListPools lists calico pools along with the docker networks they serve
+func (a *Admin) ListPools(ctx context.Context) ([]Pool, error) {
+	pools, err := a.calicoIPAM.IPPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Pool, 0, len(pools.Items))
+	for i := range pools.Items {
+		result = append(result, newPool(&pools.Items[i]))
+	}
+	return result, nil
+}
+
+// FindPoolByNetworkID returns the calico pool of the docker network
+func (a *Admin) FindPoolByNetworkID(ctx context.Context, networkID string) (*Pool, error) {
+	pool, err := a.calNetDriver.FindPoolByNetworkID(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	result := newPool(pool)
+	return &result, nil
+}
+
+// ListEndpoints lists workload endpoints of docker endpoints on this host
+func (a *Admin) ListEndpoints(ctx context.Context) ([]Endpoint, error) {
+	weps, err := a.calNetDriver.ListEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]Endpoint, 0, len(weps))
+	for _, wep := range weps {
+		endpoints = append(endpoints, Endpoint{
+			Name:          wep.Name,
+			EndpointID:    wep.Spec.Endpoint,
+			InterfaceName: wep.Spec.InterfaceName,
+			MAC:           wep.Spec.MAC,
+			IPNetworks:    wep.Spec.IPNetworks,
+			Profiles:      wep.Spec.Profiles,
+		})
+	}
+	return endpoints, nil
+}
+
+func newPool(pool *apiv3.IPPool) Pool {
+	return Pool{
+		Name:       pool.Name,
+		CIDR:       pool.Spec.CIDR,
+		NetworkIDs: calNetDriver.PoolNetworkIDs(pool),
+	}
+}
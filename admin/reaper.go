@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projecteru2/minions/types"
+)
+
+// ReleaseExpiredAddresses removes expired reservations and gives the addresses back to calico,
+// returns the released ones. A reservation is put back when its address fails to be released,
+// so the next reap retries it, and the failure is returned.
+func (a *Admin) ReleaseExpiredAddresses(ctx context.Context) ([]types.ReservedAddress, error) {
+	expired, err := a.meta.ReleaseExpiredAddresses(ctx, time.Now())
+	var released []types.ReservedAddress
+	var failed []string
+	for _, address := range expired {
+		log.Infof("[Admin::ReleaseExpiredAddresses] reservation of ip(%s) in pool(%s) expired at %v, releasing to calico",
+			address.Address, address.PoolID, address.ExpireAt)
+		releaseErr := a.calicoIPAM.ReleaseIP(ctx, address.PoolID, address.Address)
+		if releaseErr == nil {
+			released = append(released, address)
+			continue
+		}
+		log.Errorf("[Admin::ReleaseExpiredAddresses] release ip(%s) to calico error, %v", address.Address, releaseErr)
+		failed = append(failed, address.Address)
+		// it's still expired, so it's released again by the next reap
+		if putErr := a.meta.ReserveIPforContainer(ctx, &address, address.ContainerID); putErr != nil {
+			log.Errorf("[Admin::ReleaseExpiredAddresses] put back reservation of ip(%s) error, it's left assigned in calico, %v",
+				address.Address, putErr)
+		}
+	}
+	if len(failed) != 0 && err == nil {
+		err = errors.Errorf("release expired ips %s to calico failed", strings.Join(failed, ", "))
+	}
+	return released, err
+}
+
+// RunReaper releases expired reservations every interval until ctx is done
+func (a *Admin) RunReaper(ctx context.Context, interval time.Duration) {
+	log.Infof("[Admin::RunReaper] reaper started, interval = %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infoln("[Admin::RunReaper] reaper stopped")
+			return
+		case <-ticker.C:
+			if _, err := a.ReleaseExpiredAddresses(ctx); err != nil {
+				log.Errorf("[Admin::RunReaper] release expired addresses error, %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel/memory"
+	calDriver "github.com/projecteru2/minions/driver/calico"
+	calIpamDriver "github.com/projecteru2/minions/driver/calico/ipam"
+	"github.com/projecteru2/minions/types"
+)
+
+func TestReleaseExpiredAddresses(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeCalico{releaseErr: errors.New("etcd unavailable")}
+	meta := memory.NewMemory()
+	expired := &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1", ExpireAt: time.Now().Add(-time.Minute)}
+	require.NoError(t, meta.ReserveIPforContainer(ctx, expired, "c1"))
+	a := &Admin{
+		calicoIPAM: calIpamDriver.NewCalicoIPAM(client, calDriver.NewPoolCache(client, time.Minute)),
+		meta:       meta,
+	}
+
+	// the reservation is kept for the next reap when calico fails
+	released, err := a.ReleaseExpiredAddresses(ctx)
+	assert.Error(t, err)
+	assert.Empty(t, released)
+	reserved, err := meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	info := &types.ContainerInfo{ID: "c1"}
+	found, err := meta.GetContainerInfo(ctx, info)
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	client.releaseErr = nil
+	released, err = a.ReleaseExpiredAddresses(ctx)
+	require.NoError(t, err)
+	require.Len(t, released, 1)
+	assert.Equal(t, "10.0.0.1", released[0].Address)
+	assert.Equal(t, []string{"10.0.0.1"}, client.released)
+	reserved, err = meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, reserved)
+}
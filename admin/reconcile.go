@@ -0,0 +1,264 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	log "github.com/sirupsen/logrus"
+
+	calIpamDriver "github.com/projecteru2/minions/driver/calico/ipam"
+	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
+	"github.com/projecteru2/minions/types"
+)
+
+const (
+	// DriftReservedNotAssigned the ip is reserved in barrel but not assigned in calico,
+	// fixed by removing the reservation
+	DriftReservedNotAssigned = "reserved-not-assigned"
+	// DriftReservedInUse the ip is reserved in barrel but used by a running container,
+	// fixed by removing the reservation
+	DriftReservedInUse = "reserved-in-use"
+	// DriftStaleContainerRecord the container record lists an ip which is no longer reserved,
+	// fixed by removing the ip from the record
+	DriftStaleContainerRecord = "stale-container-record"
+	// DriftContainerNotAssigned the ip of a running container is not assigned in calico,
+	// fixed by assigning the ip in calico
+	DriftContainerNotAssigned = "container-not-assigned"
+	// DriftAssignedNotUsed the ip is assigned in a calico block of this node, but neither reserved in barrel,
+	// recorded for an endpoint nor used by a running container. It's reported only,
+	// since the ip may be requested for a container being started
+	DriftAssignedNotUsed = "assigned-not-used"
+)
+
+// Drift is a disagreement between barrel, calico IPAM and docker
+type Drift struct {
+	Kind        string
+	PoolID      string
+	Address     string
+	ContainerID string
+	Fixed       bool
+	Error       string `json:",omitempty"`
+}
+
+// Reconcile compares barrel reservations and container records with calico IPAM and running containers,
+// and calico assignments in blocks of this node with barrel and running containers, fixes the drifts when fix is true.
+// Calico assignments made by libnetwork carry no handle, so they are checked address by address.
+func (a *Admin) Reconcile(ctx context.Context, fix bool) ([]Drift, error) {
+	var (
+		reserved   []types.ReservedAddress
+		containers []types.ContainerInfo
+		running    map[string]string
+		drifts     []Drift
+		err        error
+	)
+	if reserved, err = a.meta.ListReservedAddresses(ctx, ""); err != nil {
+		return nil, err
+	}
+	if containers, err = a.meta.ListContainerInfos(ctx); err != nil {
+		return nil, err
+	}
+	if running, err = a.runningAddresses(ctx); err != nil {
+		return nil, err
+	}
+
+	reservedSet := make(map[types.ReservedAddress]struct{}, len(reserved))
+	reservedIPs := make(map[string]struct{}, len(reserved))
+	for _, address := range reserved {
+		address := address
+		reservedSet[types.ReservedAddress{PoolID: address.PoolID, Address: address.Address}] = struct{}{}
+		reservedIPs[address.Address] = struct{}{}
+
+		if containerID, ok := running[address.Address]; ok {
+			drift := Drift{Kind: DriftReservedInUse, PoolID: address.PoolID, Address: address.Address, ContainerID: containerID}
+			drifts = append(drifts, a.fixDrift(ctx, drift, fix, func() error {
+				_, err := a.meta.ReleaseReservedAddress(ctx, &address)
+				return err
+			}))
+			continue
+		}
+
+		assigned, err := a.calicoIPAM.IPIsAssigned(ctx, address.Address)
+		if err != nil {
+			log.Errorf("[Admin::Reconcile] get calico assignment of ip(%s) error, %v", address.Address, err)
+			continue
+		}
+		if !assigned {
+			drift := Drift{Kind: DriftReservedNotAssigned, PoolID: address.PoolID, Address: address.Address, ContainerID: address.ContainerID}
+			drifts = append(drifts, a.fixDrift(ctx, drift, fix, func() error {
+				_, err := a.meta.ReleaseReservedAddress(ctx, &address)
+				return err
+			}))
+		}
+	}
+
+	for _, info := range containers {
+		for _, address := range info.Addresses {
+			if _, ok := reservedSet[types.ReservedAddress{PoolID: address.PoolID, Address: address.Address}]; ok {
+				continue
+			}
+			address := types.ReservedAddress{PoolID: address.PoolID, Address: address.Address, ContainerID: info.ID}
+			drift := Drift{Kind: DriftStaleContainerRecord, PoolID: address.PoolID, Address: address.Address, ContainerID: info.ID}
+			drifts = append(drifts, a.fixDrift(ctx, drift, fix, func() error {
+				return a.meta.RemoveContainerAddress(ctx, &address)
+			}))
+		}
+	}
+
+	for address, containerID := range running {
+		assigned, err := a.calicoIPAM.IPIsAssigned(ctx, address)
+		if err != nil {
+			log.Errorf("[Admin::Reconcile] get calico assignment of ip(%s) error, %v", address, err)
+			continue
+		}
+		if !assigned {
+			address := address
+			drift := Drift{Kind: DriftContainerNotAssigned, Address: address, ContainerID: containerID}
+			drifts = append(drifts, a.fixDrift(ctx, drift, fix, func() error {
+				_, err := a.calicoIPAM.AssignIP(ctx, address)
+				return err
+			}))
+		}
+	}
+
+	unused, err := a.unusedAssignments(ctx, reservedIPs, running)
+	if err != nil {
+		return drifts, err
+	}
+	for _, assignment := range unused {
+		drift := Drift{Kind: DriftAssignedNotUsed, PoolID: assignment.Pool, Address: assignment.Address}
+		drifts = append(drifts, a.fixDrift(ctx, drift, false, nil))
+	}
+	return drifts, nil
+}
+
+// unusedAssignments returns calico assignments in blocks of this node, which are neither reserved,
+// recorded for an endpoint nor used by a running container. Pools serving no docker network are skipped,
+// their addresses are assigned by other orchestrators.
+func (a *Admin) unusedAssignments(
+	ctx context.Context,
+	reservedIPs map[string]struct{},
+	running map[string]string,
+) ([]calIpamDriver.Assignment, error) {
+	pools, err := a.calicoIPAM.IPPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dockerPools := make(map[string]bool, len(pools.Items))
+	for i := range pools.Items {
+		dockerPools[pools.Items[i].Name] = len(calNetDriver.PoolNetworkIDs(&pools.Items[i])) != 0
+	}
+	assignments, err := a.calicoIPAM.HostAssignments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var unused []calIpamDriver.Assignment
+	for _, assignment := range assignments {
+		if !dockerPools[assignment.Pool] {
+			continue
+		}
+		if _, ok := reservedIPs[assignment.Address]; ok {
+			continue
+		}
+		if _, ok := running[assignment.Address]; ok {
+			continue
+		}
+		// endpoints are recorded from CreateEndpoint until Leave
+		found, err := a.meta.GetEndpointByAddress(ctx, &types.Endpoint{PoolID: assignment.Pool, Address: assignment.Address})
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			unused = append(unused, assignment)
+		}
+	}
+	return unused, nil
+}
+
+// RunReconciler reconciles every interval until ctx is done
+func (a *Admin) RunReconciler(ctx context.Context, interval time.Duration, fix bool) {
+	log.Infof("[Admin::RunReconciler] reconciler started, interval = %v, fix = %v", interval, fix)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infoln("[Admin::RunReconciler] reconciler stopped")
+			return
+		case <-ticker.C:
+			if _, err := a.Reconcile(ctx, fix); err != nil {
+				log.Errorf("[Admin::RunReconciler] reconcile error, %v", err)
+			}
+		}
+	}
+}
+
+func (a *Admin) fixDrift(ctx context.Context, drift Drift, fix bool, fixFunc func() error) Drift {
+	log.Warnf("[Admin::Reconcile] drift %s found, pool = %s, ip = %s, container = %s",
+		drift.Kind, drift.PoolID, drift.Address, drift.ContainerID)
+	if !fix || ctx.Err() != nil {
+		return drift
+	}
+	if err := fixFunc(); err != nil {
+		log.Errorf("[Admin::Reconcile] fix drift %s of ip(%s) error, %v", drift.Kind, drift.Address, err)
+		drift.Error = err.Error()
+		return drift
+	}
+	log.Infof("[Admin::Reconcile] drift %s of ip(%s) fixed", drift.Kind, drift.Address)
+	drift.Fixed = true
+	return drift
+}
+
+// runningAddresses returns calico addresses of running containers on this node, keyed by address
+func (a *Admin) runningAddresses(ctx context.Context) (map[string]string, error) {
+	var (
+		pools      *apiv3.IPPoolList
+		containers []dockerTypes.Container
+		cidrs      []*net.IPNet
+		err        error
+	)
+	if pools, err = a.calicoIPAM.IPPools(ctx); err != nil {
+		return nil, err
+	}
+	for _, pool := range pools.Items {
+		_, cidr, err := net.ParseCIDR(pool.Spec.CIDR)
+		if err != nil {
+			log.Warnf("[Admin::runningAddresses] invalid CIDR %s of pool %s, %v", pool.Spec.CIDR, pool.Name, err)
+			continue
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	if containers, err = a.dockerCli.ContainerList(ctx, dockerTypes.ContainerListOptions{}); err != nil {
+		return nil, err
+	}
+
+	inPools := func(address string) bool {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return false
+		}
+		for _, cidr := range cidrs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	addresses := make(map[string]string)
+	for _, container := range containers {
+		if container.NetworkSettings == nil {
+			continue
+		}
+		for _, endpoint := range container.NetworkSettings.Networks {
+			for _, address := range []string{endpoint.IPAddress, endpoint.GlobalIPv6Address} {
+				if inPools(address) {
+					addresses[address] = container.ID
+				}
+			}
+		}
+	}
+	return addresses, nil
+}
@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerNetworkTypes "github.com/docker/docker/api/types/network"
+	dockerClient "github.com/docker/docker/client"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	cerrors "github.com/projectcalico/libcalico-go/lib/errors"
+	calicoipam "github.com/projectcalico/libcalico-go/lib/ipam"
+	caliconet "github.com/projectcalico/libcalico-go/lib/net"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel/memory"
+	calDriver "github.com/projecteru2/minions/driver/calico"
+	calIpamDriver "github.com/projecteru2/minions/driver/calico/ipam"
+	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
+	"github.com/projecteru2/minions/types"
+)
+
+// fakeCalico serves pools and blocks affine to this host, releaseErr fails releasing ips
+type fakeCalico struct {
+	clientv3.Interface
+
+	pools      []apiv3.IPPool
+	blocks     map[string]*model.AllocationBlock
+	releaseErr error
+	released   []string
+}
+
+type fakeIPPools struct {
+	clientv3.IPPoolInterface
+	*fakeCalico
+}
+
+type fakeIPAMClient struct {
+	calicoipam.Interface
+	*fakeCalico
+}
+
+type fakeBackend struct {
+	bapi.Client
+	*fakeCalico
+}
+
+func (f *fakeCalico) IPPools() clientv3.IPPoolInterface { return fakeIPPools{fakeCalico: f} }
+func (f *fakeCalico) IPAM() calicoipam.Interface        { return fakeIPAMClient{fakeCalico: f} }
+func (f *fakeCalico) Backend() bapi.Client              { return fakeBackend{fakeCalico: f} }
+
+func (f fakeIPPools) List(ctx context.Context, opts options.ListOptions) (*apiv3.IPPoolList, error) {
+	return &apiv3.IPPoolList{Items: f.pools}, nil
+}
+
+func (f fakeIPAMClient) ReleaseIPs(ctx context.Context, ips []caliconet.IP) ([]caliconet.IP, error) {
+	if f.releaseErr != nil {
+		return nil, f.releaseErr
+	}
+	for _, ip := range ips {
+		f.released = append(f.released, ip.String())
+	}
+	return nil, nil
+}
+
+func (f fakeBackend) Get(ctx context.Context, key model.Key, revision string) (*model.KVPair, error) {
+	block, ok := f.blocks[key.(model.BlockKey).CIDR.String()]
+	if !ok {
+		return nil, cerrors.ErrorResourceDoesNotExist{Identifier: key}
+	}
+	return &model.KVPair{Key: key, Value: block}, nil
+}
+
+func (f fakeBackend) List(ctx context.Context, list model.ListInterface, revision string) (*model.KVPairList, error) {
+	host := list.(model.BlockAffinityListOptions).Host
+	kvs := &model.KVPairList{}
+	for _, block := range f.blocks {
+		kvs.KVPairs = append(kvs.KVPairs, &model.KVPair{Key: model.BlockAffinityKey{CIDR: block.CIDR, Host: host}})
+	}
+	return kvs, nil
+}
+
+// newBlock returns a /26 block of cidr with ordinals assigned
+func newBlock(cidr string, ordinals ...int) *model.AllocationBlock {
+	_, ipNet, _ := caliconet.ParseCIDR(cidr)
+	block := &model.AllocationBlock{CIDR: *ipNet, Allocations: make([]*int, 64)}
+	for _, ordinal := range ordinals {
+		block.Allocations[ordinal] = new(int)
+	}
+	return block
+}
+
+func newCalicoPool(name, cidr string, networkIDs string) apiv3.IPPool {
+	pool := apiv3.NewIPPool()
+	pool.Name = name
+	pool.Spec.CIDR = cidr
+	pool.Spec.BlockSize = 26
+	if networkIDs != "" {
+		pool.Annotations = map[string]string{calNetDriver.NetworkIDAnnotation: networkIDs}
+	}
+	return *pool
+}
+
+// newDockerClient returns a docker client listing containers
+func newDockerClient(t *testing.T, containers []dockerTypes.Container) *dockerClient.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/containers/json") {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(containers)
+	}))
+	t.Cleanup(server.Close)
+	cli, err := dockerClient.NewClientWithOpts(
+		dockerClient.WithHost(strings.Replace(server.URL, "http://", "tcp://", 1)),
+		dockerClient.WithVersion("1.35"),
+	)
+	require.NoError(t, err)
+	return cli
+}
+
+func TestReconcileAssignedNotUsed(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeCalico{
+		pools: []apiv3.IPPool{
+			newCalicoPool("docker", "10.0.0.0/16", "n1"),
+			newCalicoPool("k8s", "10.1.0.0/16", ""),
+		},
+		blocks: map[string]*model.AllocationBlock{
+			"10.0.0.0/26": newBlock("10.0.0.0/26", 1, 2, 3, 4),
+			"10.1.0.0/26": newBlock("10.1.0.0/26", 1),
+		},
+	}
+	meta := memory.NewMemory()
+	// 10.0.0.1 is reserved, 10.0.0.2 is used by a running container, 10.0.0.3 is recorded for an endpoint
+	require.NoError(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: "docker", Address: "10.0.0.1"}, "c1"))
+	require.NoError(t, meta.PutEndpoint(ctx, &types.Endpoint{ID: "e3", PoolID: "docker", Address: "10.0.0.3"}))
+	running := dockerTypes.Container{ID: "c2", NetworkSettings: &dockerTypes.SummaryNetworkSettings{
+		Networks: map[string]*dockerNetworkTypes.EndpointSettings{"n1": {IPAddress: "10.0.0.2"}},
+	}}
+	a := &Admin{
+		calicoIPAM: calIpamDriver.NewCalicoIPAM(client, calDriver.NewPoolCache(client, time.Minute)),
+		dockerCli:  newDockerClient(t, []dockerTypes.Container{running}),
+		meta:       meta,
+	}
+
+	// unused addresses are reported even with fix, addresses of pools without docker networks are skipped
+	drifts, err := a.Reconcile(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, []Drift{{Kind: DriftAssignedNotUsed, PoolID: "docker", Address: "10.0.0.4"}}, drifts)
+}
@@ -0,0 +1,277 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projecteru2/minions/types"
+	"github.com/projecteru2/minions/versioninfo"
+)
+
+const (
+	// DefaultSocket is where the admin api is served, away from /run/docker/plugins,
+	// which docker scans for plugins
+	DefaultSocket = "/run/minions/admin.sock"
+
+	// admin socket is not a docker plugin, so it implements nothing
+	manifest = `{"Implements": []}`
+
+	reservedPath  = "/reserved"
+	marksPath     = "/marks"
+	poolsPath     = "/pools"
+	endpointsPath = "/endpoints"
+	versionPath   = "/version"
+)
+
+// Version is the build info of minions
+type Version struct {
+	Name      string
+	Version   string
+	Revision  string
+	BuiltAt   string
+	GoVersion string
+	OS        string
+	Arch      string
+}
+
+// ErrorResponse is returned with a non 2xx status code
+type ErrorResponse struct {
+	Err string
+}
+
+// Server serves admin operations as json over http
+type Server struct {
+	admin   *Admin
+	handler sdk.Handler
+}
+
+// NewServer .
+func NewServer(admin *Admin) *Server {
+	s := &Server{admin: admin, handler: sdk.NewHandler(manifest)}
+	s.handler.HandleFunc(reservedPath, s.reserved)
+	s.handler.HandleFunc(reservedPath+"/", s.reservedAddress)
+	s.handler.HandleFunc(marksPath, s.marks)
+	s.handler.HandleFunc(marksPath+"/", s.markedAddress)
+	s.handler.HandleFunc(poolsPath, s.pools)
+	s.handler.HandleFunc(endpointsPath, s.endpoints)
+	s.handler.HandleFunc(versionPath, s.version)
+	return s
+}
+
+// ServeUnix serves on the unix socket at addr, a plain name is placed beside DefaultSocket.
+// The socket is accessible by root only, as the api changes reservations.
+func (s *Server) ServeUnix(addr string) error {
+	if !filepath.IsAbs(addr) {
+		addr = filepath.Join(filepath.Dir(DefaultSocket), addr+".sock")
+	}
+	l, err := listenPrivateUnix(addr)
+	if err != nil {
+		return err
+	}
+	return s.handler.Serve(l)
+}
+
+// listenPrivateUnix listens on the unix socket at addr accessible by its owner only.
+// The socket is created in a private directory and moved to addr once restricted,
+// so it's never reachable by others meanwhile.
+func listenPrivateUnix(addr string) (net.Listener, error) {
+	dir := filepath.Dir(addr)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	privateDir, err := ioutil.TempDir(dir, ".minions-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(privateDir)
+
+	path := filepath.Join(privateDir, "admin.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is moved away from path, it's left on close and replaced by the next start
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = os.Chmod(path, 0600); err == nil {
+		// a stale socket at addr is replaced at once
+		err = os.Rename(path, addr)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// GET /reserved?pool=<pool>
+func (s *Server) reserved(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	addresses, err := s.admin.ListReservedAddresses(r.Context(), r.URL.Query().Get("pool"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, addresses)
+}
+
+// GET|DELETE /reserved/<ip>?pool=<pool>
+func (s *Server) reservedAddress(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	address := addressFromRequest(r, reservedPath)
+	var (
+		found bool
+		err   error
+	)
+	if r.Method == http.MethodGet {
+		found, err = s.admin.GetReservedAddress(r.Context(), address)
+	} else {
+		found, err = s.admin.ReleaseReservedAddress(r.Context(), address)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, types.ErrReservedAddressNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, address)
+}
+
+// GET /marks?pool=<pool>, POST /marks with a ReservedAddress body
+func (s *Server) marks(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		requests, err := s.admin.ListReserveRequests(r.Context(), r.URL.Query().Get("pool"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, requests)
+		return
+	}
+	request := &types.ReserveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request.ReservedAddress); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.admin.MarkReserveRequest(r.Context(), request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, request)
+}
+
+// DELETE /marks/<ip>?pool=<pool>
+func (s *Server) markedAddress(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodDelete) {
+		return
+	}
+	request := &types.ReserveRequest{ReservedAddress: *addressFromRequest(r, marksPath)}
+	canceled, err := s.admin.CancelReserveRequest(r.Context(), request)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !canceled {
+		writeError(w, http.StatusNotFound, types.ErrReserveRequestNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, request)
+}
+
+// GET /pools, GET /pools?network=<network id>
+func (s *Server) pools(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if networkID := r.URL.Query().Get("network"); networkID != "" {
+		pool, err := s.admin.FindPoolByNetworkID(r.Context(), networkID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pool)
+		return
+	}
+	pools, err := s.admin.ListPools(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pools)
+}
+
+// GET /endpoints
+func (s *Server) endpoints(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	endpoints, err := s.admin.ListEndpoints(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+// GET /version
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, Version{
+		Name:      versioninfo.NAME,
+		Version:   versioninfo.VERSION,
+		Revision:  versioninfo.REVISION,
+		BuiltAt:   versioninfo.BUILTAT,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	})
+}
+
+func addressFromRequest(r *http.Request, path string) *types.ReservedAddress {
+	return &types.ReservedAddress{
+		PoolID:  r.URL.Query().Get("pool"),
+		Address: strings.TrimPrefix(r.URL.Path, path+"/"),
+	}
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Err: "method not allowed"})
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Err: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("[Admin::Server] write response error, %v", err)
+	}
+}
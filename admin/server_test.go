@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel/memory"
+	"github.com/projecteru2/minions/types"
+	"github.com/projecteru2/minions/versioninfo"
+)
+
+func newTestServer(t *testing.T) (string, *memory.Memory) {
+	meta := memory.NewMemory()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go NewServer(&Admin{meta: meta}).handler.Serve(l) // nolint
+	t.Cleanup(func() { l.Close() })
+	return "http://" + l.Addr().String(), meta
+}
+
+func request(t *testing.T, method, url, body string, out interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestServerReserved(t *testing.T) {
+	url, meta := newTestServer(t)
+	ctx := context.Background()
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		require.NoError(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: "pool", Address: ip}, "c1"))
+	}
+	require.NoError(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: "other", Address: "10.1.0.1"}, "c2"))
+
+	var addresses []types.ReservedAddress
+	assert.Equal(t, http.StatusOK, request(t, http.MethodGet, url+"/reserved", "", &addresses))
+	assert.Len(t, addresses, 3)
+	assert.Equal(t, http.StatusOK, request(t, http.MethodGet, url+"/reserved?pool=pool", "", &addresses))
+	assert.Len(t, addresses, 2)
+
+	var address types.ReservedAddress
+	assert.Equal(t, http.StatusOK, request(t, http.MethodGet, url+"/reserved/10.1.0.1?pool=other", "", &address))
+	assert.Equal(t, "c2", address.ContainerID)
+
+	var errResp ErrorResponse
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodGet, url+"/reserved/10.1.0.1?pool=pool", "", &errResp))
+	assert.Equal(t, types.ErrReservedAddressNotFound.Error(), errResp.Err)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(t, http.MethodPost, url+"/reserved", "", &errResp))
+}
+
+func TestServerMarks(t *testing.T) {
+	url, meta := newTestServer(t)
+
+	var errResp ErrorResponse
+	assert.Equal(t, http.StatusBadRequest, request(t, http.MethodPost, url+"/marks", `{"Address": "10.0.0.1"}`, &errResp))
+	assert.Equal(t, http.StatusBadRequest, request(t, http.MethodPost, url+"/marks", `{"PoolID": "pool", "Address": "bad"}`, &errResp))
+	assert.Equal(t, http.StatusBadRequest, request(t, http.MethodPost, url+"/marks", `{`, &errResp))
+
+	var mark types.ReserveRequest
+	assert.Equal(t, http.StatusCreated, request(t, http.MethodPost, url+"/marks", `{"PoolID": "pool", "Address": "10.0.0.1"}`, &mark))
+	assert.Equal(t, "10.0.0.1", mark.Address)
+
+	var marks []types.ReserveRequest
+	assert.Equal(t, http.StatusOK, request(t, http.MethodGet, url+"/marks?pool=pool", "", &marks))
+	assert.Len(t, marks, 1)
+
+	assert.Equal(t, http.StatusOK, request(t, http.MethodDelete, url+"/marks/10.0.0.1?pool=pool", "", &mark))
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodDelete, url+"/marks/10.0.0.1?pool=pool", "", &errResp))
+	assert.Equal(t, types.ErrReserveRequestNotFound.Error(), errResp.Err)
+
+	marks, err := meta.ListReserveRequests(context.Background(), "")
+	require.NoError(t, err)
+	assert.Empty(t, marks)
+}
+
+func TestServerVersion(t *testing.T) {
+	url, _ := newTestServer(t)
+
+	var version Version
+	assert.Equal(t, http.StatusOK, request(t, http.MethodGet, url+"/version", "", &version))
+	assert.Equal(t, versioninfo.NAME, version.Name)
+	assert.Equal(t, versioninfo.VERSION, version.Version)
+}
+
+func TestListenPrivateUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minions-admin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "run", "admin.sock")
+
+	// a stale socket is replaced
+	require.NoError(t, os.MkdirAll(filepath.Dir(addr), 0700))
+	require.NoError(t, ioutil.WriteFile(addr, nil, 0666))
+	l, err := listenPrivateUnix(addr)
+	require.NoError(t, err)
+	defer l.Close()
+
+	info, err := os.Stat(addr)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode()&os.ModeSocket)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	entries, err := ioutil.ReadDir(filepath.Dir(addr))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the private directory is removed")
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", addr)
+	require.NoError(t, err)
+	conn.Close()
+}
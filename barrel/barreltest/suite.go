@@ -0,0 +1,475 @@
+// Package barreltest provides the conformance suite every barrel.Meta backend must pass.
+package barreltest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel"
+	"github.com/projecteru2/minions/types"
+)
+
+const (
+	pool      = "pool-a"
+	otherPool = "pool-b"
+)
+
+// RunMetaSuite runs the conformance suite, newMeta must return an empty Meta for each case
+func RunMetaSuite(t *testing.T, newMeta func(t *testing.T) barrel.Meta) {
+	cases := []struct {
+		name string
+		test func(t *testing.T, meta barrel.Meta)
+	}{
+		{"ReserveIPforContainer", testReserveIPforContainer},
+		{"ReserveBlankKey", testReserveBlankKey},
+		{"AquireIfReserved", testAquireIfReserved},
+		{"ConsumeMissingRequestMark", testConsumeMissingRequestMark},
+		{"RequestMarks", testRequestMarks},
+		{"ReserveConsumesRequestMark", testReserveConsumesRequestMark},
+		{"ListReservedAddresses", testListReservedAddresses},
+		{"ReleaseReservedAddress", testReleaseReservedAddress},
+		{"ReleaseExpiredAddresses", testReleaseExpiredAddresses},
+		{"RemoveContainerAddress", testRemoveContainerAddress},
+		{"ReserveMultipleAddresses", testReserveMultipleAddresses},
+		{"ReserveConcurrently", testReserveConcurrently},
+		{"Endpoints", testEndpoints},
+		{"DualStackEndpoints", testDualStackEndpoints},
+		{"SubPools", testSubPools},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.test(t, newMeta(t))
+		})
+	}
+}
+
+func reserve(t *testing.T, meta barrel.Meta, poolID, ip, containerID string) {
+	err := meta.ReserveIPforContainer(context.Background(), &types.ReservedAddress{PoolID: poolID, Address: ip}, containerID)
+	require.NoError(t, err)
+}
+
+func addressesOf(addresses []types.ReservedAddress) []string {
+	var result []string
+	for _, address := range addresses {
+		result = append(result, address.PoolID+"/"+address.Address+"@"+address.ContainerID)
+	}
+	return result
+}
+
+func testReserveIPforContainer(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	address := &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}
+	require.NoError(t, meta.ReserveIPforContainer(ctx, address, "c1"))
+
+	got := &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}
+	reserved, err := meta.IPIsReserved(ctx, got)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Equal(t, "c1", got.ContainerID)
+
+	reserved, err = meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: otherPool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, reserved, "reservations are scoped by pool")
+
+	info := &types.ContainerInfo{ID: "c1"}
+	found, err := meta.GetContainerInfo(ctx, info)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []string{pool + "/10.0.0.1@"}, addressesOf(info.Addresses))
+
+	found, err = meta.GetContainerInfo(ctx, &types.ContainerInfo{ID: "c2"})
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func testReserveBlankKey(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	assert.Error(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: pool}, "c1"))
+	assert.Error(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}, ""))
+}
+
+func testAquireIfReserved(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	reserve(t, meta, pool, "10.0.0.1", "c1")
+
+	// reservations of other identities are left
+	acquired, err := meta.AquireIfReserved(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1", ContainerID: "c2"})
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	address := &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}
+	acquired, err = meta.AquireIfReserved(ctx, address)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, "c1", address.ContainerID)
+
+	acquired, err = meta.AquireIfReserved(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, acquired, "an address can only be acquired once")
+
+	reserved, err := meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, reserved)
+
+	// only the acquired address leaves the container record
+	reserve(t, meta, pool, "10.0.0.2", "c2")
+	reserve(t, meta, pool, "10.0.0.3", "c2")
+	acquired, err = meta.AquireIfReserved(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.2", ContainerID: "c2"})
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	info := &types.ContainerInfo{ID: "c2"}
+	found, err := meta.GetContainerInfo(ctx, info)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []string{pool + "/10.0.0.3@"}, addressesOf(info.Addresses))
+
+	acquired, err = meta.AquireIfReserved(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.3"})
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	found, err = meta.GetContainerInfo(ctx, &types.ContainerInfo{ID: "c2"})
+	require.NoError(t, err)
+	assert.False(t, found, "the record is removed with its last address")
+
+	found, err = meta.GetContainerInfo(ctx, &types.ContainerInfo{ID: "c1"})
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func testConsumeMissingRequestMark(t *testing.T, meta barrel.Meta) {
+	consumed, err := meta.ConsumeRequestMarkIfPresent(context.Background(), &types.ReserveRequest{
+		ReservedAddress: types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"},
+	})
+	require.NoError(t, err)
+	assert.False(t, consumed)
+}
+
+func testRequestMarks(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	mark := func(poolID, ip string) *types.ReserveRequest {
+		return &types.ReserveRequest{ReservedAddress: types.ReservedAddress{PoolID: poolID, Address: ip}}
+	}
+	require.NoError(t, meta.MarkReserveRequest(ctx, mark(pool, "10.0.0.1")))
+	require.NoError(t, meta.MarkReserveRequest(ctx, mark(otherPool, "10.0.1.1")))
+	require.NoError(t, meta.MarkReserveRequest(ctx, mark("", "10.0.2.1")))
+	assert.Error(t, meta.MarkReserveRequest(ctx, mark(pool, "")))
+	// marks and reservations don't see each other
+	reserve(t, meta, pool, "10.0.0.2", "c1")
+
+	requests, err := meta.ListReserveRequests(ctx, pool)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "10.0.0.1", requests[0].Address)
+
+	requests, err = meta.ListReserveRequests(ctx, "")
+	require.NoError(t, err)
+	var marked []types.ReservedAddress
+	for _, request := range requests {
+		marked = append(marked, request.ReservedAddress)
+	}
+	assert.ElementsMatch(t, []string{pool + "/10.0.0.1@", otherPool + "/10.0.1.1@", "/10.0.2.1@"}, addressesOf(marked))
+
+	addresses, err := meta.ListReservedAddresses(ctx, pool)
+	require.NoError(t, err)
+	assert.Equal(t, []string{pool + "/10.0.0.2@c1"}, addressesOf(addresses))
+
+	consumed, err := meta.ConsumeRequestMarkIfPresent(ctx, mark(pool, "10.0.0.1"))
+	require.NoError(t, err)
+	assert.True(t, consumed)
+	consumed, err = meta.ConsumeRequestMarkIfPresent(ctx, mark(pool, "10.0.0.1"))
+	require.NoError(t, err)
+	assert.False(t, consumed)
+
+	requests, err = meta.ListReserveRequests(ctx, pool)
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+}
+
+func testReserveConsumesRequestMark(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	request := &types.ReserveRequest{ReservedAddress: types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}}
+	require.NoError(t, meta.MarkReserveRequest(ctx, request))
+	// checking doesn't consume the mark
+	for i := 0; i < 2; i++ {
+		marked, err := meta.RequestMarkIsPresent(ctx, request)
+		require.NoError(t, err)
+		assert.True(t, marked)
+	}
+	marked, err := meta.RequestMarkIsPresent(ctx, &types.ReserveRequest{
+		ReservedAddress: types.ReservedAddress{PoolID: otherPool, Address: "10.0.0.1"},
+	})
+	require.NoError(t, err)
+	assert.False(t, marked, "marks are scoped by pool")
+
+	// a failed reservation keeps the mark
+	assert.Error(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}, ""))
+	marked, err = meta.RequestMarkIsPresent(ctx, request)
+	require.NoError(t, err)
+	assert.True(t, marked)
+
+	reserve(t, meta, pool, "10.0.0.1", "c1")
+	marked, err = meta.RequestMarkIsPresent(ctx, request)
+	require.NoError(t, err)
+	assert.False(t, marked)
+	requests, err := meta.ListReserveRequests(ctx, pool)
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+}
+
+func testListReservedAddresses(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	reserve(t, meta, pool, "10.0.0.1", "c1")
+	reserve(t, meta, pool, "10.0.0.2", "c2")
+	reserve(t, meta, otherPool, "10.0.1.1", "c3")
+	reserve(t, meta, "", "10.0.2.1", "c4")
+
+	addresses, err := meta.ListReservedAddresses(ctx, pool)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{pool + "/10.0.0.1@c1", pool + "/10.0.0.2@c2"}, addressesOf(addresses))
+
+	addresses, err = meta.ListReservedAddresses(ctx, "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		pool + "/10.0.0.1@c1",
+		pool + "/10.0.0.2@c2",
+		otherPool + "/10.0.1.1@c3",
+		"/10.0.2.1@c4",
+	}, addressesOf(addresses))
+
+	addresses, err = meta.ListReservedAddresses(ctx, "pool-none")
+	require.NoError(t, err)
+	assert.Empty(t, addresses)
+}
+
+func testReleaseReservedAddress(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	reserve(t, meta, pool, "10.0.0.1", "c1")
+
+	address := &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}
+	released, err := meta.ReleaseReservedAddress(ctx, address)
+	require.NoError(t, err)
+	assert.True(t, released)
+	assert.Equal(t, "c1", address.ContainerID)
+
+	found, err := meta.GetContainerInfo(ctx, &types.ContainerInfo{ID: "c1"})
+	require.NoError(t, err)
+	assert.False(t, found, "container record should be removed with its last address")
+
+	released, err = meta.ReleaseReservedAddress(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, released)
+}
+
+func testReleaseExpiredAddresses(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	now := time.Now()
+	for ip, expireAt := range map[string]time.Time{
+		"10.0.0.1": now.Add(-time.Minute),
+		"10.0.0.2": now.Add(time.Hour),
+		"10.0.0.3": {},
+	} {
+		address := &types.ReservedAddress{PoolID: pool, Address: ip, ExpireAt: expireAt}
+		require.NoError(t, meta.ReserveIPforContainer(ctx, address, "c-"+ip))
+	}
+
+	released, err := meta.ReleaseExpiredAddresses(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{pool + "/10.0.0.1@c-10.0.0.1"}, addressesOf(released))
+
+	addresses, err := meta.ListReservedAddresses(ctx, pool)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{pool + "/10.0.0.2@c-10.0.0.2", pool + "/10.0.0.3@c-10.0.0.3"}, addressesOf(addresses))
+
+	found, err := meta.GetContainerInfo(ctx, &types.ContainerInfo{ID: "c-10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	released, err = meta.ReleaseExpiredAddresses(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{pool + "/10.0.0.2@c-10.0.0.2"}, addressesOf(released))
+}
+
+func testRemoveContainerAddress(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	reserve(t, meta, pool, "10.0.0.1", "c1")
+	reserve(t, meta, pool, "10.0.0.2", "c2")
+
+	infos, err := meta.ListContainerInfos(ctx)
+	require.NoError(t, err)
+	assert.Len(t, infos, 2)
+
+	assert.Error(t, meta.RemoveContainerAddress(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"}))
+	require.NoError(t, meta.RemoveContainerAddress(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1", ContainerID: "c1"}))
+
+	infos, err = meta.ListContainerInfos(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "c2", infos[0].ID)
+
+	reserved, err := meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.True(t, reserved, "removing the container record keeps the reservation")
+}
+
+func testReserveMultipleAddresses(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	reserve(t, meta, pool, "10.0.0.1", "c1")
+	reserve(t, meta, otherPool, "10.0.1.1", "c1")
+	reserve(t, meta, pool, "10.0.0.1", "c1")
+
+	info := &types.ContainerInfo{ID: "c1"}
+	found, err := meta.GetContainerInfo(ctx, info)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.ElementsMatch(t, []string{pool + "/10.0.0.1@", otherPool + "/10.0.1.1@"}, addressesOf(info.Addresses))
+
+	released, err := meta.ReleaseReservedAddress(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	require.True(t, released)
+
+	info = &types.ContainerInfo{ID: "c1"}
+	found, err = meta.GetContainerInfo(ctx, info)
+	require.NoError(t, err)
+	require.True(t, found, "container record should be kept while it has addresses")
+	assert.Equal(t, []string{otherPool + "/10.0.1.1@"}, addressesOf(info.Addresses))
+
+	reserved, err := meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: otherPool, Address: "10.0.1.1"})
+	require.NoError(t, err)
+	assert.True(t, reserved)
+}
+
+func testReserveConcurrently(t *testing.T, meta barrel.Meta) {
+	const count = 20
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, count)
+	)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			address := &types.ReservedAddress{PoolID: pool, Address: fmt.Sprintf("10.0.0.%d", i)}
+			errs[i] = meta.ReserveIPforContainer(context.Background(), address, "c1")
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	info := &types.ContainerInfo{ID: "c1"}
+	found, err := meta.GetContainerInfo(context.Background(), info)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Len(t, info.Addresses, count, "no reservation should be lost by concurrent updates")
+}
+
+func testEndpoints(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	endpoint := &types.Endpoint{ID: "e1", NetworkID: "n1", PoolID: pool, Address: "10.0.0.1"}
+	require.NoError(t, meta.PutEndpoint(ctx, endpoint))
+	assert.Error(t, meta.PutEndpoint(ctx, &types.Endpoint{ID: "e2", PoolID: pool}))
+	// endpoints and reservations don't see each other
+	reserved, err := meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: pool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, reserved)
+
+	endpoint.ContainerID = "c1"
+	endpoint.ContainerName = "/web"
+	endpoint.Labels = map[string]string{"fixed-ip": "1"}
+	require.NoError(t, meta.PutEndpoint(ctx, endpoint))
+
+	got := &types.Endpoint{ID: "e1"}
+	found, err := meta.GetEndpoint(ctx, got)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, endpoint, got)
+
+	got = &types.Endpoint{PoolID: pool, Address: "10.0.0.1"}
+	found, err = meta.GetEndpointByAddress(ctx, got)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, endpoint, got)
+
+	found, err = meta.GetEndpointByAddress(ctx, &types.Endpoint{PoolID: otherPool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, meta.DeleteEndpoint(ctx, got))
+	found, err = meta.GetEndpoint(ctx, &types.Endpoint{ID: "e1"})
+	require.NoError(t, err)
+	assert.False(t, found)
+	found, err = meta.GetEndpointByAddress(ctx, &types.Endpoint{PoolID: pool, Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func testDualStackEndpoints(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	endpoint := &types.Endpoint{ID: "e1", PoolID: pool, Address: "10.0.0.1", PoolIDIPv6: otherPool, AddressIPv6: "fd00::1"}
+	require.NoError(t, meta.PutEndpoint(ctx, endpoint))
+
+	got := &types.Endpoint{PoolID: otherPool, Address: "fd00::1"}
+	found, err := meta.GetEndpointByAddress(ctx, got)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, endpoint, got)
+	got = &types.Endpoint{PoolID: pool, Address: "10.0.0.1"}
+	found, err = meta.GetEndpointByAddress(ctx, got)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, endpoint, got)
+
+	require.NoError(t, meta.DeleteEndpoint(ctx, got))
+	found, err = meta.GetEndpointByAddress(ctx, &types.Endpoint{PoolID: otherPool, Address: "fd00::1"})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// IPv6 only
+	endpoint = &types.Endpoint{ID: "e2", PoolIDIPv6: otherPool, AddressIPv6: "fd00::2"}
+	require.NoError(t, meta.PutEndpoint(ctx, endpoint))
+	got = &types.Endpoint{PoolID: otherPool, Address: "fd00::2"}
+	found, err = meta.GetEndpointByAddress(ctx, got)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, endpoint, got)
+	require.NoError(t, meta.DeleteEndpoint(ctx, got))
+	found, err = meta.GetEndpoint(ctx, &types.Endpoint{ID: "e2"})
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func testSubPools(t *testing.T, meta barrel.Meta) {
+	ctx := context.Background()
+	subPool := &types.SubPool{PoolID: pool, CIDR: "10.0.1.0/24"}
+	found, err := meta.GetSubPool(ctx, &types.SubPool{PoolID: pool})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, meta.PutSubPool(ctx, subPool))
+	// putting the same range again is fine
+	require.NoError(t, meta.PutSubPool(ctx, subPool))
+	assert.Equal(t, types.ErrSubPoolConflict, meta.PutSubPool(ctx, &types.SubPool{PoolID: pool, CIDR: "10.0.2.0/24"}))
+	assert.Equal(t, types.ErrKeyIsBlank, meta.PutSubPool(ctx, &types.SubPool{CIDR: "10.0.2.0/24"}))
+
+	got := &types.SubPool{PoolID: pool}
+	found, err = meta.GetSubPool(ctx, got)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, *subPool, *got)
+	found, err = meta.GetSubPool(ctx, &types.SubPool{PoolID: otherPool})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, meta.DeleteSubPool(ctx, subPool))
+	found, err = meta.GetSubPool(ctx, &types.SubPool{PoolID: pool})
+	require.NoError(t, err)
+	assert.False(t, found)
+	require.NoError(t, meta.PutSubPool(ctx, &types.SubPool{PoolID: pool, CIDR: "10.0.2.0/24"}))
+}
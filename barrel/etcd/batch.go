@@ -0,0 +1,148 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/pkg/errors"
+)
+
+// rollbackTimeout bounds rolling back a failed batch whose context is done,
+// the batch may have failed for the very deadline of its context
+const rollbackTimeout = 10 * time.Second
+
+// BatchState describes what a failed atomic batch left in the store
+type BatchState int
+
+const (
+	// BatchNotApplied no chunk of the batch was committed
+	BatchNotApplied BatchState = iota
+	// BatchRolledBack some chunks were committed and then rolled back, the store is unchanged
+	BatchRolledBack
+	// BatchPartiallyApplied some chunks are still committed, see BatchError.AppliedKeys
+	BatchPartiallyApplied
+)
+
+func (s BatchState) String() string {
+	switch s {
+	case BatchNotApplied:
+		return "not applied"
+	case BatchRolledBack:
+		return "rolled back"
+	case BatchPartiallyApplied:
+		return "partially applied"
+	default:
+		return fmt.Sprintf("BatchState(%d)", int(s))
+	}
+}
+
+// BatchError is returned by PutMultiAtomic when the batch failed
+type BatchError struct {
+	State BatchState
+	// AppliedKeys are the keys left written when State is BatchPartiallyApplied
+	AppliedKeys []string
+	Err         error
+}
+
+func (e *BatchError) Error() string {
+	if e.State == BatchPartiallyApplied {
+		return fmt.Sprintf("batch put %s, %d keys left written: %v", e.State, len(e.AppliedKeys), e.Err)
+	}
+	return fmt.Sprintf("batch put %s: %v", e.State, e.Err)
+}
+
+// Cause .
+func (e *BatchError) Cause() error {
+	return e.Err
+}
+
+// PutMultiAtomic writes all keys or none of them.
+// Keys are committed in chunks of txnLimit, when any chunk fails the committed chunks
+// are rolled back to their previous values unless the keys were changed meanwhile.
+func (e *Etcd) PutMultiAtomic(ctx context.Context, encoders ...Encoder) error {
+	data, err := encodeAll(encoders)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ops := make([]clientv3.Op, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, clientv3.OpPut(key, data[key], clientv3.WithPrevKV()))
+	}
+
+	chunks := chunkOps(ops)
+	resps, errs := e.commitChunks(ctx, nil, chunks, nil)
+	var firstErr error
+	for _, err := range errs {
+		if err != nil {
+			firstErr = err
+			break
+		}
+	}
+	if firstErr == nil {
+		return nil
+	}
+
+	rollbackCtx := ctx
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		rollbackCtx, cancel = context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+	}
+	state := BatchNotApplied
+	var appliedKeys []string
+	for i, resp := range resps {
+		if errs[i] != nil {
+			continue
+		}
+		state = BatchRolledBack
+		if err := e.rollbackChunk(rollbackCtx, chunks[i], resp); err != nil {
+			for _, op := range chunks[i] {
+				appliedKeys = append(appliedKeys, string(op.KeyBytes()))
+			}
+		}
+	}
+	if len(appliedKeys) != 0 {
+		state = BatchPartiallyApplied
+	}
+	return &BatchError{State: state, AppliedKeys: appliedKeys, Err: firstErr}
+}
+
+// rollbackChunk restores keys of a committed chunk to their previous values,
+// only when none of them is changed after the chunk was committed
+func (e *Etcd) rollbackChunk(ctx context.Context, ops []clientv3.Op, resp *clientv3.TxnResponse) error {
+	conds := make([]clientv3.Cmp, 0, len(ops))
+	undo := make([]clientv3.Op, 0, len(ops))
+	for i, op := range ops {
+		key := string(op.KeyBytes())
+		conds = append(conds, clientv3.Compare(clientv3.ModRevision(key), "=", resp.Header.Revision))
+		undo = append(undo, undoOp(key, resp.Responses[i]))
+	}
+	undoResp, err := e.cliv3.Txn(ctx).If(conds...).Then(undo...).Commit()
+	if err != nil {
+		return err
+	}
+	if !undoResp.Succeeded {
+		return errors.Errorf("keys changed after revision %d", resp.Header.Revision)
+	}
+	return nil
+}
+
+func undoOp(key string, resp *etcdserverpb.ResponseOp) clientv3.Op {
+	if prev := resp.GetResponsePut().GetPrevKv(); prev != nil {
+		return clientv3.OpPut(key, string(prev.Value))
+	}
+	return clientv3.OpDelete(key)
+}
@@ -18,10 +18,7 @@ func (codec *ReservedAddressCodec) Key() string {
 	if codec.Address.Address == "" {
 		return ""
 	}
-	if codec.Address.PoolID == "" {
-		return fmt.Sprintf("/barrel/addresses/%s", codec.Address.Address)
-	}
-	return fmt.Sprintf("/barrel/pools/%s/addresses/%s", codec.Address.PoolID, codec.Address.Address)
+	return reservedAddressPrefix(codec.Address.PoolID) + codec.Address.Address
 }
 
 // Encode .
@@ -55,7 +52,7 @@ func (codec ContainerInfoCodec) Key() string {
 	if codec.Info.ID == "" {
 		return ""
 	}
-	return fmt.Sprintf("/barrel/containers/%s", codec.Info.ID)
+	return containersPrefix + codec.Info.ID
 }
 
 // Encode .
@@ -89,10 +86,7 @@ func (codec ReserveRequestCodec) Key() string {
 	if codec.Request.Address == "" {
 		return ""
 	}
-	if codec.Request.PoolID == "" {
-		return fmt.Sprintf("/barrel/reservereqs/%s", codec.Request.Address)
-	}
-	return fmt.Sprintf("/barrel/pools/%s/reservereqs/%s", codec.Request.PoolID, codec.Request.Address)
+	return reserveRequestPrefix(codec.Request.PoolID) + codec.Request.Address
 }
 
 // Encode .
@@ -115,6 +109,139 @@ func (codec ReserveRequestCodec) Decode(input string) error {
 	return json.Unmarshal([]byte(input), codec.Request)
 }
 
+// EndpointCodec saves the endpoint by ID
+type EndpointCodec struct {
+	Endpoint *types.Endpoint
+	version  int64
+}
+
+// Key .
+func (codec EndpointCodec) Key() string {
+	if codec.Endpoint.ID == "" {
+		return ""
+	}
+	return endpointsPrefix + codec.Endpoint.ID
+}
+
+// Encode .
+func (codec EndpointCodec) Encode() (string, error) {
+	return marshal(codec.Endpoint)
+}
+
+// SetVersion .
+func (codec *EndpointCodec) SetVersion(version int64) {
+	codec.version = version
+}
+
+// Version .
+func (codec *EndpointCodec) Version() int64 {
+	return codec.version
+}
+
+// Decode .
+func (codec EndpointCodec) Decode(input string) error {
+	return json.Unmarshal([]byte(input), codec.Endpoint)
+}
+
+// EndpointAddressCodec saves the endpoint by its IPv4 address, or IPv6 address when IPv6 is true
+type EndpointAddressCodec struct {
+	Endpoint *types.Endpoint
+	IPv6     bool
+	version  int64
+}
+
+// Key .
+func (codec EndpointAddressCodec) Key() string {
+	poolID, address := codec.Endpoint.PoolID, codec.Endpoint.Address
+	if codec.IPv6 {
+		poolID, address = codec.Endpoint.PoolIDIPv6, codec.Endpoint.AddressIPv6
+	}
+	if address == "" {
+		return ""
+	}
+	return endpointAddressPrefix(poolID) + address
+}
+
+// Encode .
+func (codec EndpointAddressCodec) Encode() (string, error) {
+	return marshal(codec.Endpoint)
+}
+
+// SetVersion .
+func (codec *EndpointAddressCodec) SetVersion(version int64) {
+	codec.version = version
+}
+
+// Version .
+func (codec *EndpointAddressCodec) Version() int64 {
+	return codec.version
+}
+
+// Decode .
+func (codec EndpointAddressCodec) Decode(input string) error {
+	return json.Unmarshal([]byte(input), codec.Endpoint)
+}
+
+// SubPoolCodec .
+type SubPoolCodec struct {
+	SubPool *types.SubPool
+	version int64
+}
+
+// Key .
+func (codec SubPoolCodec) Key() string {
+	if codec.SubPool.PoolID == "" {
+		return ""
+	}
+	return fmt.Sprintf("/barrel/pools/%s/subpool", codec.SubPool.PoolID)
+}
+
+// Encode .
+func (codec SubPoolCodec) Encode() (string, error) {
+	return marshal(codec.SubPool)
+}
+
+// SetVersion .
+func (codec *SubPoolCodec) SetVersion(version int64) {
+	codec.version = version
+}
+
+// Version .
+func (codec *SubPoolCodec) Version() int64 {
+	return codec.version
+}
+
+// Decode .
+func (codec SubPoolCodec) Decode(input string) error {
+	return json.Unmarshal([]byte(input), codec.SubPool)
+}
+
+const (
+	containersPrefix = "/barrel/containers/"
+	endpointsPrefix  = "/barrel/endpoints/"
+)
+
+func reservedAddressPrefix(poolID string) string {
+	if poolID == "" {
+		return "/barrel/addresses/"
+	}
+	return fmt.Sprintf("/barrel/pools/%s/addresses/", poolID)
+}
+
+func reserveRequestPrefix(poolID string) string {
+	if poolID == "" {
+		return "/barrel/reservereqs/"
+	}
+	return fmt.Sprintf("/barrel/pools/%s/reservereqs/", poolID)
+}
+
+func endpointAddressPrefix(poolID string) string {
+	if poolID == "" {
+		return "/barrel/endpointaddrs/"
+	}
+	return fmt.Sprintf("/barrel/pools/%s/endpoints/", poolID)
+}
+
 func marshal(src interface{}) (string, error) {
 	bytes, err := json.Marshal(src)
 	return string(bytes), err
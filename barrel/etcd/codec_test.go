@@ -4,9 +4,50 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/types"
 )
 
-func TestMarshal(t *testing.T) {
-	var bytes []byte
-	assert.Equal(t, "", string(bytes), "this shouldn't happen")
+func TestCodecKeys(t *testing.T) {
+	address := types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"}
+	assert.Equal(t, "/barrel/pools/pool/addresses/10.0.0.1", (&ReservedAddressCodec{Address: &address}).Key())
+	assert.Equal(t, "/barrel/addresses/10.0.0.1", (&ReservedAddressCodec{Address: &types.ReservedAddress{Address: "10.0.0.1"}}).Key())
+	assert.Equal(t, "", (&ReservedAddressCodec{Address: &types.ReservedAddress{PoolID: "pool"}}).Key())
+
+	request := &types.ReserveRequest{ReservedAddress: address}
+	assert.Equal(t, "/barrel/pools/pool/reservereqs/10.0.0.1", ReserveRequestCodec{Request: request}.Key())
+	assert.Equal(t, "/barrel/reservereqs/10.0.0.1", ReserveRequestCodec{Request: &types.ReserveRequest{
+		ReservedAddress: types.ReservedAddress{Address: "10.0.0.1"},
+	}}.Key())
+
+	assert.Equal(t, "/barrel/containers/c1", ContainerInfoCodec{Info: &types.ContainerInfo{ID: "c1"}}.Key())
+	assert.Equal(t, "", ContainerInfoCodec{Info: &types.ContainerInfo{}}.Key())
+
+	endpoint := &types.Endpoint{ID: "e1", PoolID: "pool", Address: "10.0.0.1"}
+	assert.Equal(t, "/barrel/endpoints/e1", EndpointCodec{Endpoint: endpoint}.Key())
+	assert.Equal(t, "/barrel/pools/pool/endpoints/10.0.0.1", EndpointAddressCodec{Endpoint: endpoint}.Key())
+	assert.Equal(t, "/barrel/endpointaddrs/10.0.0.1", EndpointAddressCodec{Endpoint: &types.Endpoint{Address: "10.0.0.1"}}.Key())
+	assert.Equal(t, "", EndpointCodec{Endpoint: &types.Endpoint{Address: "10.0.0.1"}}.Key())
+	endpoint = &types.Endpoint{ID: "e1", PoolIDIPv6: "pool6", AddressIPv6: "fd00::1"}
+	assert.Equal(t, "", EndpointAddressCodec{Endpoint: endpoint}.Key())
+	assert.Equal(t, "/barrel/pools/pool6/endpoints/fd00::1", EndpointAddressCodec{Endpoint: endpoint, IPv6: true}.Key())
+
+	assert.Equal(t, "/barrel/pools/pool/subpool", SubPoolCodec{SubPool: &types.SubPool{PoolID: "pool"}}.Key())
+	assert.Equal(t, "", SubPoolCodec{SubPool: &types.SubPool{CIDR: "10.0.0.0/24"}}.Key())
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	info := &types.ContainerInfo{
+		ID:        "c1",
+		Addresses: []types.ReservedAddress{{PoolID: "pool", Address: "10.0.0.1"}},
+	}
+	encoded, err := ContainerInfoCodec{Info: info}.Encode()
+	require.NoError(t, err)
+
+	decoded := &types.ContainerInfo{}
+	require.NoError(t, ContainerInfoCodec{Info: decoded}.Decode(encoded))
+	assert.Equal(t, info, decoded)
+
+	assert.Error(t, ContainerInfoCodec{Info: decoded}.Decode("not json"))
 }
@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
-	"github.com/pkg/errors"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/projectcalico/libcalico-go/lib/apiconfig"
 	"github.com/projecteru2/minions/types"
 )
@@ -16,6 +16,9 @@ const (
 	cmpVersion = "version"
 	cmpValue   = "value"
 
+	// stupid etcd txn, default limit is 128
+	txnLimit = 125
+
 	clientTimeout    = 10 * time.Second
 	keepaliveTime    = 30 * time.Second
 	keepaliveTimeout = 10 * time.Second
@@ -23,7 +26,7 @@ const (
 
 var (
 	// ErrKeyIsBlank .
-	ErrKeyIsBlank = errors.New("Key shouldn't be blank")
+	ErrKeyIsBlank = types.ErrKeyIsBlank
 )
 
 // Etcd .
@@ -85,6 +88,18 @@ func (e *Etcd) Get(ctx context.Context, decoder Decoder) (bool, error) {
 	return err == nil, err
 }
 
+// GetPrefix returns all key values with the prefix
+func (e *Etcd) GetPrefix(ctx context.Context, prefix string) ([]*mvccpb.KeyValue, error) {
+	if prefix == "" {
+		return nil, ErrKeyIsBlank
+	}
+	resp, err := e.cliv3.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Kvs, nil
+}
+
 // Put save a key value
 func (e *Etcd) Put(ctx context.Context, encoder Encoder) error {
 	var (
@@ -119,6 +134,29 @@ func (e *Etcd) Delete(ctx context.Context, encoder Encoder) (bool, error) {
 	return len(resp.PrevKvs) > 0, nil
 }
 
+// CompareAndDelete delete key when its version is not changed
+// returns true on delete count > 0
+func (e *Etcd) CompareAndDelete(ctx context.Context, encoder Encoder) (bool, error) {
+	var (
+		key  = encoder.Key()
+		resp *clientv3.TxnResponse
+		err  error
+	)
+	if key == "" {
+		return false, ErrKeyIsBlank
+	}
+	if resp, err = e.cliv3.Txn(
+		ctx,
+	).If(
+		clientv3.Compare(clientv3.Version(key), "=", encoder.Version()),
+	).Then(
+		clientv3.OpDelete(key),
+	).Commit(); err != nil {
+		return false, err
+	}
+	return resp.Succeeded && resp.Responses[0].GetResponseDeleteRange().Deleted > 0, nil
+}
+
 // GetAndDelete delete key, and return value
 // returns true on delete count > 0
 func (e *Etcd) GetAndDelete(ctx context.Context, decoder Decoder) (bool, error) {
@@ -140,32 +178,81 @@ func (e *Etcd) GetAndDelete(ctx context.Context, decoder Decoder) (bool, error)
 	return err == nil, err
 }
 
-// Update .
-func (e *Etcd) Update(ctx context.Context, encoder Encoder) (bool, error) {
+// Update puts encoder when its version is unchanged, version 0 means the key must not exist.
+// puts are written in the same txn only when the update succeeds
+func (e *Etcd) Update(ctx context.Context, encoder Encoder, puts ...Encoder) (bool, error) {
 	var (
-		value string
-		err   error
-		resp  *clientv3.TxnResponse
+		data map[string]string
+		err  error
+		resp *clientv3.TxnResponse
 	)
-	if value, err = encoder.Encode(); err != nil {
+	key := encoder.Key()
+	if data, err = encodeAll(append([]Encoder{encoder}, puts...)); err != nil {
 		return false, err
 	}
-	key := encoder.Key()
+	ops := make([]clientv3.Op, 0, len(data))
+	for k, v := range data {
+		ops = append(ops, clientv3.OpPut(k, v))
+	}
 	prevVersion := encoder.Version()
 	if resp, err = e.cliv3.Txn(
 		ctx,
 	).If(
 		clientv3.Compare(clientv3.Version(key), "=", prevVersion),
 	).Then(
-		clientv3.OpPut(key, value),
+		ops...,
 	).Commit(); err != nil {
 		return false, err
 	}
 	return resp.Succeeded, err
 }
 
+// commitIfUnchanged commits ops in one txn when none of encoders changed since read,
+// version 0 means the key must not exist
+func (e *Etcd) commitIfUnchanged(ctx context.Context, encoders []Encoder, ops ...clientv3.Op) (bool, error) {
+	conds := make([]clientv3.Cmp, 0, len(encoders))
+	for _, encoder := range encoders {
+		key := encoder.Key()
+		if key == "" {
+			return false, ErrKeyIsBlank
+		}
+		conds = append(conds, clientv3.Compare(clientv3.Version(key), "=", encoder.Version()))
+	}
+	resp, err := e.cliv3.Txn(ctx).If(conds...).Then(ops...).Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
 // PutMulti .
+// keys are written in chunks of txnLimit, so the write is not atomic across chunks,
+// use PutMultiAtomic for all-or-nothing writes
 func (e *Etcd) PutMulti(ctx context.Context, encoders ...Encoder) error {
+	data, err := encodeAll(encoders)
+	if err != nil {
+		return err
+	}
+	_, err = e.batchPut(ctx, data, nil)
+	return err
+}
+
+// DeleteMulti deletes keys of encoders,
+// keys are deleted in chunks of txnLimit like PutMulti
+func (e *Etcd) DeleteMulti(ctx context.Context, encoders ...Encoder) error {
+	ops := make([]clientv3.Op, 0, len(encoders))
+	for _, encoder := range encoders {
+		key := encoder.Key()
+		if key == "" {
+			return ErrKeyIsBlank
+		}
+		ops = append(ops, clientv3.OpDelete(key))
+	}
+	_, err := e.doBatchOp(ctx, nil, ops, nil)
+	return err
+}
+
+func encodeAll(encoders []Encoder) (map[string]string, error) {
 	data := make(map[string]string)
 	for _, encoder := range encoders {
 		var (
@@ -174,15 +261,14 @@ func (e *Etcd) PutMulti(ctx context.Context, encoders ...Encoder) error {
 			err error
 		)
 		if key == "" {
-			return ErrKeyIsBlank
+			return nil, ErrKeyIsBlank
 		}
 		if val, err = encoder.Encode(); err != nil {
-			return err
+			return nil, err
 		}
 		data[key] = val
 	}
-	_, err := e.batchPut(ctx, data, nil)
-	return err
+	return data, nil
 }
 
 // BatchPut .
@@ -220,16 +306,48 @@ func (e *Etcd) doBatchOp(ctx context.Context, conds []clientv3.Cmp, ops, failOps
 		return nil, types.ErrNoOps
 	}
 
-	const txnLimit = 125
-	count := len(ops) / txnLimit // stupid etcd txn, default limit is 128
-	tail := len(ops) % txnLimit
-	length := count
-	if tail != 0 {
-		length++
+	resps, errs := e.commitChunks(ctx, conds, chunkOps(ops), failOps)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(resps) == 0 {
+		return &clientv3.TxnResponse{}, nil
 	}
 
-	resps := make([]*clientv3.TxnResponse, length)
-	errs := make([]error, length)
+	resp := resps[0]
+	for i := 1; i < len(resps); i++ {
+		resp.Succeeded = resp.Succeeded && resps[i].Succeeded
+		resp.Responses = append(resp.Responses, resps[i].Responses...)
+	}
+	return resp, nil
+}
+
+// chunkOps splits ops into chunks of txnLimit
+func chunkOps(ops []clientv3.Op) [][]clientv3.Op {
+	chunks := make([][]clientv3.Op, 0, (len(ops)+txnLimit-1)/txnLimit)
+	for start := 0; start < len(ops); start += txnLimit {
+		end := start + txnLimit
+		if end > len(ops) {
+			end = len(ops)
+		}
+		chunks = append(chunks, ops[start:end])
+	}
+	return chunks
+}
+
+// commitChunks commits each chunk in its own txn concurrently,
+// returns the response and error of each chunk
+func (e *Etcd) commitChunks(
+	ctx context.Context,
+	conds []clientv3.Cmp,
+	chunks [][]clientv3.Op,
+	failOps []clientv3.Op,
+) ([]*clientv3.TxnResponse, []error) {
+	resps := make([]*clientv3.TxnResponse, len(chunks))
+	errs := make([]error, len(chunks))
 
 	wg := sync.WaitGroup{}
 	doOp := func(index int, ops []clientv3.Op) {
@@ -243,31 +361,10 @@ func (e *Etcd) doBatchOp(ctx context.Context, conds []clientv3.Cmp, ops, failOps
 		errs[index] = err
 	}
 
-	if tail != 0 {
-		wg.Add(1)
-		go doOp(length-1, ops[count*txnLimit:])
-	}
-
-	for i := 0; i < count; i++ {
+	for i, ops := range chunks {
 		wg.Add(1)
-		go doOp(i, ops[i*txnLimit:(i+1)*txnLimit])
+		go doOp(i, ops)
 	}
 	wg.Wait()
-
-	for _, err := range errs {
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	if len(resps) == 0 {
-		return &clientv3.TxnResponse{}, nil
-	}
-
-	resp := resps[0]
-	for i := 1; i < len(resps); i++ {
-		resp.Succeeded = resp.Succeeded && resps[i].Succeeded
-		resp.Responses = append(resp.Responses, resps[i].Responses...)
-	}
-	return resp, nil
+	return resps, errs
 }
@@ -0,0 +1,335 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/types"
+)
+
+var testEndpoint string
+
+func TestMain(m *testing.M) {
+	os.Exit(runWithEmbeddedEtcd(m))
+}
+
+func runWithEmbeddedEtcd(m *testing.M) int {
+	dir, err := ioutil.TempDir("", "minions-etcd")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogPkgLevels = "*=C"
+	clientURL, peerURL := localURL(), localURL()
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer server.Close()
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(clientTimeout):
+		fmt.Fprintln(os.Stderr, "embedded etcd took too long to start")
+		return 1
+	}
+	testEndpoint = clientURL.String()
+	return m.Run()
+}
+
+func localURL() url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// newTestEtcd returns an Etcd connected to the embedded server with all barrel keys removed
+func newTestEtcd(t *testing.T) *Etcd {
+	cliv3, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{testEndpoint},
+		DialTimeout: clientTimeout,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { cliv3.Close() })
+	_, err = cliv3.Delete(context.Background(), "/barrel/", clientv3.WithPrefix())
+	require.NoError(t, err)
+	return &Etcd{cliv3}
+}
+
+func testAddress(ip string) *ReservedAddressCodec {
+	return &ReservedAddressCodec{Address: &types.ReservedAddress{PoolID: "pool", Address: ip}}
+}
+
+func TestPutGet(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+
+	found, err := e.Get(ctx, testAddress("10.0.0.1"))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, e.Put(ctx, &ReservedAddressCodec{
+		Address: &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1", ContainerID: "c1"},
+	}))
+	codec := testAddress("10.0.0.1")
+	found, err = e.Get(ctx, codec)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "c1", codec.Address.ContainerID)
+	assert.Equal(t, int64(1), codec.Version())
+
+	require.NoError(t, e.Put(ctx, testAddress("10.0.0.1")))
+	found, err = e.Get(ctx, codec)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(2), codec.Version())
+
+	assert.Equal(t, ErrKeyIsBlank, e.Put(ctx, testAddress("")))
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+	require.NoError(t, e.Put(ctx, testAddress("10.0.0.1")))
+
+	deleted, err := e.Delete(ctx, testAddress("10.0.0.1"))
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	deleted, err = e.Delete(ctx, testAddress("10.0.0.1"))
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	_, err = e.Delete(ctx, testAddress(""))
+	assert.Equal(t, ErrKeyIsBlank, err)
+}
+
+func TestGetAndDelete(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+	require.NoError(t, e.Put(ctx, &ReservedAddressCodec{
+		Address: &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1", ContainerID: "c1"},
+	}))
+
+	codec := testAddress("10.0.0.1")
+	deleted, err := e.GetAndDelete(ctx, codec)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	assert.Equal(t, "c1", codec.Address.ContainerID)
+
+	found, err := e.Get(ctx, testAddress("10.0.0.1"))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	deleted, err = e.GetAndDelete(ctx, testAddress("10.0.0.1"))
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+
+	// version 0 means the key must not exist
+	created, err := e.Update(ctx, testAddress("10.0.0.1"))
+	require.NoError(t, err)
+	assert.True(t, created)
+	created, err = e.Update(ctx, testAddress("10.0.0.1"))
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	codec := testAddress("10.0.0.1")
+	_, err = e.Get(ctx, codec)
+	require.NoError(t, err)
+	stale := testAddress("10.0.0.1")
+	stale.SetVersion(codec.Version())
+
+	codec.Address.ContainerID = "c1"
+	updated, err := e.Update(ctx, codec)
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	stale.Address.ContainerID = "c2"
+	updated, err = e.Update(ctx, stale)
+	require.NoError(t, err)
+	assert.False(t, updated, "update with a stale version should fail")
+
+	got := testAddress("10.0.0.1")
+	_, err = e.Get(ctx, got)
+	require.NoError(t, err)
+	assert.Equal(t, "c1", got.Address.ContainerID)
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+	require.NoError(t, e.Put(ctx, testAddress("10.0.0.1")))
+
+	codec := testAddress("10.0.0.1")
+	_, err := e.Get(ctx, codec)
+	require.NoError(t, err)
+	require.NoError(t, e.Put(ctx, testAddress("10.0.0.1")))
+
+	deleted, err := e.CompareAndDelete(ctx, codec)
+	require.NoError(t, err)
+	assert.False(t, deleted, "delete with a stale version should fail")
+
+	_, err = e.Get(ctx, codec)
+	require.NoError(t, err)
+	deleted, err = e.CompareAndDelete(ctx, codec)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+func TestPutMulti(t *testing.T) {
+	ctx := context.Background()
+	for _, count := range []int{1, 125, 126, 250, 301} {
+		count := count
+		t.Run(fmt.Sprintf("%d keys", count), func(t *testing.T) {
+			e := newTestEtcd(t)
+			var encoders []Encoder
+			for i := 0; i < count; i++ {
+				encoders = append(encoders, testAddress(fmt.Sprintf("10.0.%d.%d", i/256, i%256)))
+			}
+			require.NoError(t, e.PutMulti(ctx, encoders...))
+
+			kvs, err := e.GetPrefix(ctx, reservedAddressPrefix("pool"))
+			require.NoError(t, err)
+			assert.Len(t, kvs, count)
+		})
+	}
+
+	e := newTestEtcd(t)
+	assert.Equal(t, ErrKeyIsBlank, e.PutMulti(ctx, testAddress("10.0.0.1"), testAddress("")))
+	kvs, err := e.GetPrefix(ctx, reservedAddressPrefix("pool"))
+	require.NoError(t, err)
+	assert.Empty(t, kvs, "nothing should be written when any key is blank")
+}
+
+func TestDoBatchOp(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+	const guard = "/barrel/test/guard"
+
+	_, err := e.doBatchOp(ctx, nil, nil, nil)
+	assert.Equal(t, types.ErrNoOps, err)
+
+	ops := func(count int) []clientv3.Op {
+		var ops []clientv3.Op
+		for i := 0; i < count; i++ {
+			ops = append(ops, clientv3.OpPut(fmt.Sprintf("/barrel/test/%03d", i), "v"))
+		}
+		return ops
+	}
+	conds := []clientv3.Cmp{clientv3.Compare(clientv3.Version(guard), "=", 0)}
+	failOps := []clientv3.Op{clientv3.OpGet(guard)}
+
+	// 301 ops are split into 3 chunks, responses of the chunks are merged in order
+	resp, err := e.doBatchOp(ctx, conds, ops(301), failOps)
+	require.NoError(t, err)
+	assert.True(t, resp.Succeeded)
+	require.Len(t, resp.Responses, 301)
+	for _, r := range resp.Responses {
+		assert.NotNil(t, r.GetResponsePut())
+	}
+
+	// every chunk fails on the guard, so the merged response holds the else ops of each chunk
+	_, err = e.cliv3.Put(ctx, guard, "exists")
+	require.NoError(t, err)
+	resp, err = e.doBatchOp(ctx, conds, ops(251), failOps)
+	require.NoError(t, err)
+	assert.False(t, resp.Succeeded)
+	require.Len(t, resp.Responses, 3)
+	for _, r := range resp.Responses {
+		require.NotNil(t, r.GetResponseRange())
+		assert.Equal(t, "exists", string(r.GetResponseRange().Kvs[0].Value))
+	}
+}
+
+func TestGetPrefix(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+	require.NoError(t, e.PutMulti(ctx, testAddress("10.0.0.1"), testAddress("10.0.0.2")))
+	require.NoError(t, e.Put(ctx, &ReserveRequestCodec{
+		Request: &types.ReserveRequest{ReservedAddress: types.ReservedAddress{PoolID: "pool", Address: "10.0.0.3"}},
+	}))
+
+	kvs, err := e.GetPrefix(ctx, reservedAddressPrefix("pool"))
+	require.NoError(t, err)
+	require.Len(t, kvs, 2)
+	assert.Equal(t, "/barrel/pools/pool/addresses/10.0.0.1", string(kvs[0].Key))
+	assert.Equal(t, "/barrel/pools/pool/addresses/10.0.0.2", string(kvs[1].Key))
+
+	_, err = e.GetPrefix(ctx, "")
+	assert.Equal(t, ErrKeyIsBlank, err)
+}
+
+type rawCodec struct {
+	key, value string
+}
+
+func (c rawCodec) Key() string             { return c.key }
+func (c rawCodec) Encode() (string, error) { return c.value, nil }
+func (c rawCodec) Version() int64          { return 0 }
+
+func TestPutMultiAtomic(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+
+	var encoders []Encoder
+	for i := 0; i < 300; i++ {
+		encoders = append(encoders, rawCodec{fmt.Sprintf("/barrel/test/%03d", i), "new"})
+	}
+	require.NoError(t, e.PutMultiAtomic(ctx, encoders...))
+	kvs, err := e.GetPrefix(ctx, "/barrel/test/")
+	require.NoError(t, err)
+	assert.Len(t, kvs, 300)
+
+	// the last chunk exceeds the request size limit, so the first two chunks must be rolled back
+	e = newTestEtcd(t)
+	_, err = e.cliv3.Put(ctx, "/barrel/test/000", "old")
+	require.NoError(t, err)
+	encoders[len(encoders)-1] = rawCodec{"/barrel/test/299", strings.Repeat("x", 2*1024*1024)}
+	err = e.PutMultiAtomic(ctx, encoders...)
+	require.Error(t, err)
+	batchErr, ok := err.(*BatchError)
+	require.True(t, ok)
+	assert.Equal(t, BatchRolledBack, batchErr.State)
+	assert.Empty(t, batchErr.AppliedKeys)
+
+	kvs, err = e.GetPrefix(ctx, "/barrel/test/")
+	require.NoError(t, err)
+	require.Len(t, kvs, 1, "keys created by the batch should be deleted")
+	assert.Equal(t, "old", string(kvs[0].Value), "keys overwritten by the batch should be restored")
+
+	// a batch fitting in one chunk fails as a whole
+	err = e.PutMultiAtomic(ctx, rawCodec{"/barrel/test/000", "new"}, rawCodec{"/barrel/test/001", strings.Repeat("x", 2*1024*1024)})
+	require.Error(t, err)
+	batchErr, ok = err.(*BatchError)
+	require.True(t, ok)
+	assert.Equal(t, BatchNotApplied, batchErr.State)
+}
@@ -2,20 +2,51 @@ package etcd
 
 import (
 	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/pkg/errors"
 
 	"github.com/projecteru2/minions/types"
 )
 
+const (
+	poolsPrefix          = "/barrel/pools/"
+	addressesInfix       = "/addresses/"
+	reserveRequestsInfix = "/reservereqs/"
+
+	maxUpdateRetries   = 16
+	updateRetryBackoff = 20 * time.Millisecond
+)
+
 // ReserveIPforContainer .
 func (e *Etcd) ReserveIPforContainer(ctx context.Context, address *types.ReservedAddress, containerID string) error {
-	container := &types.ContainerInfo{
-		ID: containerID,
-		Addresses: []types.ReservedAddress{{
-			PoolID:  address.PoolID,
-			Address: address.Address,
-		}},
+	// callers reuse address, so the container is set on a copy
+	reserved := *address
+	reserved.ContainerID = containerID
+	entry := types.ReservedAddress{
+		PoolID:  address.PoolID,
+		Address: address.Address,
+	}
+	codec := &ReservedAddressCodec{Address: &reserved}
+	value, err := codec.Encode()
+	if err != nil {
+		return err
 	}
-	return e.PutMulti(ctx, &ContainerInfoCodec{Info: container}, &ReservedAddressCodec{Address: address})
+	// the request mark is consumed only along with a successful reservation,
+	// so a failed one can be retried with the mark still there
+	request := &ReserveRequestCodec{Request: &types.ReserveRequest{ReservedAddress: entry}}
+	return e.updateContainerInfo(ctx, containerID, func(info *types.ContainerInfo) {
+		for _, addr := range info.Addresses {
+			if addr.PoolID == entry.PoolID && addr.Address == entry.Address {
+				return
+			}
+		}
+		info.Addresses = append(info.Addresses, entry)
+	}, clientv3.OpPut(codec.Key(), value), clientv3.OpDelete(request.Key()))
 }
 
 // IPIsReserved .
@@ -23,12 +54,354 @@ func (e *Etcd) IPIsReserved(ctx context.Context, address *types.ReservedAddress)
 	return e.Get(ctx, &ReservedAddressCodec{Address: address})
 }
 
+// RequestMarkIsPresent .
+func (e *Etcd) RequestMarkIsPresent(ctx context.Context, request *types.ReserveRequest) (bool, error) {
+	return e.Get(ctx, &ReserveRequestCodec{Request: request})
+}
+
 // ConsumeRequestMarkIfPresent .
 func (e *Etcd) ConsumeRequestMarkIfPresent(ctx context.Context, request *types.ReserveRequest) (bool, error) {
 	return e.Delete(ctx, &ReserveRequestCodec{Request: request})
 }
 
 // AquireIfReserved .
+// The address is removed from the record of its container in the same txn
 func (e *Etcd) AquireIfReserved(ctx context.Context, address *types.ReservedAddress) (bool, error) {
-	return e.Delete(ctx, &ReservedAddressCodec{Address: address})
+	for i := 0; i < maxUpdateRetries; i++ {
+		reserved := &types.ReservedAddress{PoolID: address.PoolID, Address: address.Address}
+		codec := &ReservedAddressCodec{Address: reserved}
+		found, err := e.Get(ctx, codec)
+		if err != nil || !found {
+			return false, err
+		}
+		if address.ContainerID != "" && reserved.ContainerID != address.ContainerID {
+			return false, nil
+		}
+		var acquired bool
+		if reserved.ContainerID == "" {
+			acquired, err = e.CompareAndDelete(ctx, codec)
+		} else {
+			acquired, err = e.deleteWithContainerAddress(ctx, codec)
+		}
+		if err != nil {
+			return false, err
+		}
+		if acquired {
+			address.ContainerID = reserved.ContainerID
+			return true, nil
+		}
+	}
+	return false, errors.Errorf("too many conflicts on acquiring ip(%s)", address.Address)
+}
+
+// deleteWithContainerAddress deletes the reservation along with its entry in the container record,
+// returns false when either is changed since read
+func (e *Etcd) deleteWithContainerAddress(ctx context.Context, codec *ReservedAddressCodec) (bool, error) {
+	address := codec.Address
+	info := &types.ContainerInfo{ID: address.ContainerID}
+	infoCodec := &ContainerInfoCodec{Info: info}
+	exists, err := e.Get(ctx, infoCodec)
+	if err != nil {
+		return false, err
+	}
+	ops := []clientv3.Op{clientv3.OpDelete(codec.Key())}
+	if exists {
+		addresses := info.Addresses[:0]
+		for _, addr := range info.Addresses {
+			if addr.PoolID != address.PoolID || addr.Address != address.Address {
+				addresses = append(addresses, addr)
+			}
+		}
+		info.Addresses = addresses
+		info.ID = address.ContainerID
+		if len(addresses) == 0 {
+			ops = append(ops, clientv3.OpDelete(infoCodec.Key()))
+		} else {
+			value, err := infoCodec.Encode()
+			if err != nil {
+				return false, err
+			}
+			ops = append(ops, clientv3.OpPut(infoCodec.Key(), value))
+		}
+	}
+	return e.commitIfUnchanged(ctx, []Encoder{codec, infoCodec}, ops...)
+}
+
+// MarkReserveRequest .
+func (e *Etcd) MarkReserveRequest(ctx context.Context, request *types.ReserveRequest) error {
+	return e.Put(ctx, &ReserveRequestCodec{Request: request})
+}
+
+// ListReserveRequests .
+func (e *Etcd) ListReserveRequests(ctx context.Context, poolID string) ([]types.ReserveRequest, error) {
+	kvs, err := e.listPoolKeys(ctx, poolID, reserveRequestPrefix, reserveRequestsInfix)
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]types.ReserveRequest, 0, len(kvs))
+	for _, kv := range kvs {
+		var request types.ReserveRequest
+		if err = (ReserveRequestCodec{Request: &request}).Decode(string(kv.Value)); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// ListReservedAddresses .
+func (e *Etcd) ListReservedAddresses(ctx context.Context, poolID string) ([]types.ReservedAddress, error) {
+	codecs, err := e.listReservedAddresses(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]types.ReservedAddress, 0, len(codecs))
+	for _, codec := range codecs {
+		addresses = append(addresses, *codec.Address)
+	}
+	return addresses, nil
+}
+
+// GetContainerInfo .
+func (e *Etcd) GetContainerInfo(ctx context.Context, info *types.ContainerInfo) (bool, error) {
+	return e.Get(ctx, &ContainerInfoCodec{Info: info})
+}
+
+// ListContainerInfos .
+func (e *Etcd) ListContainerInfos(ctx context.Context) ([]types.ContainerInfo, error) {
+	kvs, err := e.GetPrefix(ctx, containersPrefix)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]types.ContainerInfo, 0, len(kvs))
+	for _, kv := range kvs {
+		var info types.ContainerInfo
+		if err = (ContainerInfoCodec{Info: &info}).Decode(string(kv.Value)); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// RemoveContainerAddress .
+func (e *Etcd) RemoveContainerAddress(ctx context.Context, address *types.ReservedAddress) error {
+	if address.ContainerID == "" {
+		return ErrKeyIsBlank
+	}
+	return e.removeContainerAddress(ctx, address)
+}
+
+// ReleaseReservedAddress .
+func (e *Etcd) ReleaseReservedAddress(ctx context.Context, address *types.ReservedAddress) (bool, error) {
+	released, err := e.GetAndDelete(ctx, &ReservedAddressCodec{Address: address})
+	if err != nil || !released || address.ContainerID == "" {
+		return released, err
+	}
+	return true, e.removeContainerAddress(ctx, address)
+}
+
+// ReleaseExpiredAddresses .
+func (e *Etcd) ReleaseExpiredAddresses(ctx context.Context, now time.Time) ([]types.ReservedAddress, error) {
+	codecs, err := e.listReservedAddresses(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var released []types.ReservedAddress
+	for _, codec := range codecs {
+		if !codec.Address.Expired(now) {
+			continue
+		}
+		// the reservation may be acquired or renewed meanwhile, so delete by version
+		var deleted bool
+		if deleted, err = e.CompareAndDelete(ctx, codec); err != nil {
+			return released, err
+		}
+		if !deleted {
+			continue
+		}
+		released = append(released, *codec.Address)
+		if codec.Address.ContainerID == "" {
+			continue
+		}
+		if err = e.removeContainerAddress(ctx, codec.Address); err != nil {
+			return released, err
+		}
+	}
+	return released, nil
+}
+
+// PutEndpoint .
+func (e *Etcd) PutEndpoint(ctx context.Context, endpoint *types.Endpoint) error {
+	if len(endpoint.Addresses()) == 0 {
+		return ErrKeyIsBlank
+	}
+	// the record and its address indexes are written all or nothing, so lookups by address never miss a saved record
+	return e.PutMultiAtomic(ctx, endpointCodecs(endpoint)...)
+}
+
+// GetEndpoint .
+func (e *Etcd) GetEndpoint(ctx context.Context, endpoint *types.Endpoint) (bool, error) {
+	return e.Get(ctx, &EndpointCodec{Endpoint: endpoint})
+}
+
+// GetEndpointByAddress .
+func (e *Etcd) GetEndpointByAddress(ctx context.Context, endpoint *types.Endpoint) (bool, error) {
+	return e.Get(ctx, &EndpointAddressCodec{Endpoint: endpoint})
+}
+
+// DeleteEndpoint .
+func (e *Etcd) DeleteEndpoint(ctx context.Context, endpoint *types.Endpoint) error {
+	if len(endpoint.Addresses()) == 0 {
+		return ErrKeyIsBlank
+	}
+	// at most 3 keys, which DeleteMulti removes in one txn
+	return e.DeleteMulti(ctx, endpointCodecs(endpoint)...)
+}
+
+// endpointCodecs returns codecs of the endpoint by ID and by its addresses
+func endpointCodecs(endpoint *types.Endpoint) []Encoder {
+	encoders := []Encoder{&EndpointCodec{Endpoint: endpoint}}
+	if endpoint.Address != "" {
+		encoders = append(encoders, &EndpointAddressCodec{Endpoint: endpoint})
+	}
+	if endpoint.AddressIPv6 != "" {
+		encoders = append(encoders, &EndpointAddressCodec{Endpoint: endpoint, IPv6: true})
+	}
+	return encoders
+}
+
+// PutSubPool .
+func (e *Etcd) PutSubPool(ctx context.Context, subPool *types.SubPool) error {
+	for i := 0; i < maxUpdateRetries; i++ {
+		// version 0 creates the key only when it's absent
+		created, err := e.Update(ctx, &SubPoolCodec{SubPool: subPool})
+		if err != nil || created {
+			return err
+		}
+		existing := &types.SubPool{PoolID: subPool.PoolID}
+		found, err := e.GetSubPool(ctx, existing)
+		if err != nil {
+			return err
+		}
+		if !found {
+			// deleted in between, try creating again
+			continue
+		}
+		if existing.CIDR != subPool.CIDR {
+			return types.ErrSubPoolConflict
+		}
+		return nil
+	}
+	return errors.Errorf("too many conflicts on putting sub pool of %s", subPool.PoolID)
+}
+
+// GetSubPool .
+func (e *Etcd) GetSubPool(ctx context.Context, subPool *types.SubPool) (bool, error) {
+	return e.Get(ctx, &SubPoolCodec{SubPool: subPool})
+}
+
+// DeleteSubPool .
+func (e *Etcd) DeleteSubPool(ctx context.Context, subPool *types.SubPool) error {
+	_, err := e.Delete(ctx, &SubPoolCodec{SubPool: subPool})
+	return err
+}
+
+func (e *Etcd) listReservedAddresses(ctx context.Context, poolID string) ([]*ReservedAddressCodec, error) {
+	kvs, err := e.listPoolKeys(ctx, poolID, reservedAddressPrefix, addressesInfix)
+	if err != nil {
+		return nil, err
+	}
+	codecs := make([]*ReservedAddressCodec, 0, len(kvs))
+	for _, kv := range kvs {
+		codec := &ReservedAddressCodec{Address: &types.ReservedAddress{}}
+		if err = codec.Decode(string(kv.Value)); err != nil {
+			return nil, err
+		}
+		codec.SetVersion(kv.Version)
+		codecs = append(codecs, codec)
+	}
+	return codecs, nil
+}
+
+// listPoolKeys lists keys under prefix(poolID), lists keys of all pools and the pool-less ones when poolID is blank
+func (e *Etcd) listPoolKeys(ctx context.Context, poolID string, prefix func(string) string, infix string) ([]*mvccpb.KeyValue, error) {
+	kvs, err := e.GetPrefix(ctx, prefix(poolID))
+	if err != nil || poolID != "" {
+		return kvs, err
+	}
+	poolKVs, err := e.GetPrefix(ctx, poolsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range poolKVs {
+		// pools prefix covers all kinds of keys of pools
+		if strings.Contains(string(kv.Key), infix) {
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs, nil
+}
+
+func (e *Etcd) removeContainerAddress(ctx context.Context, address *types.ReservedAddress) error {
+	return e.updateContainerInfo(ctx, address.ContainerID, func(info *types.ContainerInfo) {
+		addresses := info.Addresses[:0]
+		for _, addr := range info.Addresses {
+			if addr.PoolID != address.PoolID || addr.Address != address.Address {
+				addresses = append(addresses, addr)
+			}
+		}
+		info.Addresses = addresses
+	})
+}
+
+// updateContainerInfo applies update on the container record by version, and retries on conflicts.
+// The record is written or deleted along with ops in one txn, it's deleted when no address is left.
+func (e *Etcd) updateContainerInfo(
+	ctx context.Context,
+	containerID string,
+	update func(info *types.ContainerInfo),
+	ops ...clientv3.Op,
+) error {
+	if containerID == "" {
+		return ErrKeyIsBlank
+	}
+	for i := 0; i < maxUpdateRetries; i++ {
+		if i > 0 {
+			// jitter to spread conflicting writers
+			backoff := time.Duration(rand.Int63n(int64(updateRetryBackoff))) // nolint:gosec
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+		info := &types.ContainerInfo{ID: containerID}
+		codec := &ContainerInfoCodec{Info: info}
+		exists, err := e.Get(ctx, codec)
+		if err != nil {
+			return err
+		}
+		update(info)
+		info.ID = containerID
+
+		var recordOps []clientv3.Op
+		switch {
+		case len(info.Addresses) != 0:
+			value, err := codec.Encode()
+			if err != nil {
+				return err
+			}
+			recordOps = append(recordOps, clientv3.OpPut(codec.Key(), value))
+		case exists:
+			recordOps = append(recordOps, clientv3.OpDelete(codec.Key()))
+		case len(ops) == 0:
+			return nil
+		}
+		done, err := e.commitIfUnchanged(ctx, []Encoder{codec}, append(recordOps, ops...)...)
+		if err != nil || done {
+			return err
+		}
+	}
+	return errors.Errorf("too many conflicts on updating container %s", containerID)
 }
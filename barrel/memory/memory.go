@@ -0,0 +1,358 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/projecteru2/minions/types"
+)
+
+type addressKey struct {
+	poolID  string
+	address string
+}
+
+func keyOf(address types.ReservedAddress) addressKey {
+	return addressKey{poolID: address.PoolID, address: address.Address}
+}
+
+// Memory is a thread-safe in-memory barrel.Meta, for tests and single-node setups
+type Memory struct {
+	mu         sync.Mutex
+	reserved   map[addressKey]types.ReservedAddress
+	containers map[string]types.ContainerInfo
+	requests   map[addressKey]types.ReserveRequest
+	endpoints  map[string]types.Endpoint
+	// endpointIDs indexes endpoints by address
+	endpointIDs map[addressKey]string
+	subPools    map[string]types.SubPool
+}
+
+// NewMemory .
+func NewMemory() *Memory {
+	return &Memory{
+		reserved:    make(map[addressKey]types.ReservedAddress),
+		containers:  make(map[string]types.ContainerInfo),
+		requests:    make(map[addressKey]types.ReserveRequest),
+		endpoints:   make(map[string]types.Endpoint),
+		endpointIDs: make(map[addressKey]string),
+		subPools:    make(map[string]types.SubPool),
+	}
+}
+
+// ReserveIPforContainer .
+func (m *Memory) ReserveIPforContainer(ctx context.Context, address *types.ReservedAddress, containerID string) error {
+	if address.Address == "" || containerID == "" {
+		return types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reserved := *address
+	reserved.ContainerID = containerID
+	m.reserved[keyOf(reserved)] = reserved
+	delete(m.requests, keyOf(reserved))
+
+	info := m.containers[containerID]
+	info.ID = containerID
+	for _, addr := range info.Addresses {
+		if addr.PoolID == address.PoolID && addr.Address == address.Address {
+			return nil
+		}
+	}
+	info.Addresses = append(info.Addresses, types.ReservedAddress{
+		PoolID:  address.PoolID,
+		Address: address.Address,
+	})
+	m.containers[containerID] = info
+	return nil
+}
+
+// IPIsReserved .
+func (m *Memory) IPIsReserved(ctx context.Context, address *types.ReservedAddress) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reserved, ok := m.reserved[keyOf(*address)]
+	if ok {
+		*address = reserved
+	}
+	return ok, nil
+}
+
+// RequestMarkIsPresent .
+func (m *Memory) RequestMarkIsPresent(ctx context.Context, request *types.ReserveRequest) (bool, error) {
+	if request.Address == "" {
+		return false, types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.requests[keyOf(request.ReservedAddress)]
+	return ok, nil
+}
+
+// ConsumeRequestMarkIfPresent .
+func (m *Memory) ConsumeRequestMarkIfPresent(ctx context.Context, request *types.ReserveRequest) (bool, error) {
+	if request.Address == "" {
+		return false, types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := keyOf(request.ReservedAddress)
+	_, ok := m.requests[key]
+	delete(m.requests, key)
+	return ok, nil
+}
+
+// AquireIfReserved .
+func (m *Memory) AquireIfReserved(ctx context.Context, address *types.ReservedAddress) (bool, error) {
+	if address.Address == "" {
+		return false, types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := keyOf(*address)
+	reserved, ok := m.reserved[key]
+	if !ok || (address.ContainerID != "" && reserved.ContainerID != address.ContainerID) {
+		return false, nil
+	}
+	delete(m.reserved, key)
+	m.removeContainerAddress(&reserved)
+	address.ContainerID = reserved.ContainerID
+	return true, nil
+}
+
+// MarkReserveRequest .
+func (m *Memory) MarkReserveRequest(ctx context.Context, request *types.ReserveRequest) error {
+	if request.Address == "" {
+		return types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[keyOf(request.ReservedAddress)] = *request
+	return nil
+}
+
+// ListReserveRequests .
+func (m *Memory) ListReserveRequests(ctx context.Context, poolID string) ([]types.ReserveRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var requests []types.ReserveRequest
+	for _, request := range m.requests {
+		if poolID == "" || request.PoolID == poolID {
+			requests = append(requests, request)
+		}
+	}
+	return requests, nil
+}
+
+// ListReservedAddresses .
+func (m *Memory) ListReservedAddresses(ctx context.Context, poolID string) ([]types.ReservedAddress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var addresses []types.ReservedAddress
+	for _, address := range m.reserved {
+		if poolID == "" || address.PoolID == poolID {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// GetContainerInfo .
+func (m *Memory) GetContainerInfo(ctx context.Context, info *types.ContainerInfo) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.containers[info.ID]
+	if ok {
+		*info = copyContainerInfo(stored)
+	}
+	return ok, nil
+}
+
+// ListContainerInfos .
+func (m *Memory) ListContainerInfos(ctx context.Context) ([]types.ContainerInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	infos := make([]types.ContainerInfo, 0, len(m.containers))
+	for _, info := range m.containers {
+		infos = append(infos, copyContainerInfo(info))
+	}
+	return infos, nil
+}
+
+// RemoveContainerAddress .
+func (m *Memory) RemoveContainerAddress(ctx context.Context, address *types.ReservedAddress) error {
+	if address.ContainerID == "" {
+		return types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeContainerAddress(address)
+	return nil
+}
+
+// ReleaseReservedAddress .
+func (m *Memory) ReleaseReservedAddress(ctx context.Context, address *types.ReservedAddress) (bool, error) {
+	if address.Address == "" {
+		return false, types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := keyOf(*address)
+	reserved, ok := m.reserved[key]
+	if !ok {
+		return false, nil
+	}
+	delete(m.reserved, key)
+	*address = reserved
+	m.removeContainerAddress(address)
+	return true, nil
+}
+
+// ReleaseExpiredAddresses .
+func (m *Memory) ReleaseExpiredAddresses(ctx context.Context, now time.Time) ([]types.ReservedAddress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var released []types.ReservedAddress
+	for key, address := range m.reserved {
+		if !address.Expired(now) {
+			continue
+		}
+		delete(m.reserved, key)
+		m.removeContainerAddress(&address)
+		released = append(released, address)
+	}
+	return released, nil
+}
+
+// removeContainerAddress must be called with m.mu held
+func (m *Memory) removeContainerAddress(address *types.ReservedAddress) {
+	info, ok := m.containers[address.ContainerID]
+	if !ok {
+		return
+	}
+	var addresses []types.ReservedAddress
+	for _, addr := range info.Addresses {
+		if addr.PoolID != address.PoolID || addr.Address != address.Address {
+			addresses = append(addresses, addr)
+		}
+	}
+	if len(addresses) == 0 {
+		delete(m.containers, info.ID)
+		return
+	}
+	info.Addresses = addresses
+	m.containers[info.ID] = info
+}
+
+func copyContainerInfo(info types.ContainerInfo) types.ContainerInfo {
+	info.Addresses = append([]types.ReservedAddress(nil), info.Addresses...)
+	return info
+}
+
+// PutEndpoint .
+func (m *Memory) PutEndpoint(ctx context.Context, endpoint *types.Endpoint) error {
+	if endpoint.ID == "" || len(endpoint.Addresses()) == 0 {
+		return types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.endpoints[endpoint.ID] = *endpoint
+	for _, address := range endpoint.Addresses() {
+		m.endpointIDs[addressKey{poolID: address.PoolID, address: address.Address}] = endpoint.ID
+	}
+	return nil
+}
+
+// GetEndpoint .
+func (m *Memory) GetEndpoint(ctx context.Context, endpoint *types.Endpoint) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found, ok := m.endpoints[endpoint.ID]
+	if ok {
+		*endpoint = found
+	}
+	return ok, nil
+}
+
+// GetEndpointByAddress .
+func (m *Memory) GetEndpointByAddress(ctx context.Context, endpoint *types.Endpoint) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ok := m.endpointIDs[addressKey{poolID: endpoint.PoolID, address: endpoint.Address}]
+	if !ok {
+		return false, nil
+	}
+	*endpoint = m.endpoints[id]
+	return true, nil
+}
+
+// DeleteEndpoint .
+func (m *Memory) DeleteEndpoint(ctx context.Context, endpoint *types.Endpoint) error {
+	if endpoint.ID == "" || len(endpoint.Addresses()) == 0 {
+		return types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.endpoints, endpoint.ID)
+	for _, address := range endpoint.Addresses() {
+		delete(m.endpointIDs, addressKey{poolID: address.PoolID, address: address.Address})
+	}
+	return nil
+}
+
+// PutSubPool .
+func (m *Memory) PutSubPool(ctx context.Context, subPool *types.SubPool) error {
+	if subPool.PoolID == "" {
+		return types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.subPools[subPool.PoolID]; ok && existing.CIDR != subPool.CIDR {
+		return types.ErrSubPoolConflict
+	}
+	m.subPools[subPool.PoolID] = *subPool
+	return nil
+}
+
+// GetSubPool .
+func (m *Memory) GetSubPool(ctx context.Context, subPool *types.SubPool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found, ok := m.subPools[subPool.PoolID]
+	if ok {
+		*subPool = found
+	}
+	return ok, nil
+}
+
+// DeleteSubPool .
+func (m *Memory) DeleteSubPool(ctx context.Context, subPool *types.SubPool) error {
+	if subPool.PoolID == "" {
+		return types.ErrKeyIsBlank
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.subPools, subPool.PoolID)
+	return nil
+}
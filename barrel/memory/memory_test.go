@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/projecteru2/minions/barrel"
+	"github.com/projecteru2/minions/barrel/barreltest"
+)
+
+func TestMemoryMeta(t *testing.T) {
+	barreltest.RunMetaSuite(t, func(t *testing.T) barrel.Meta {
+		return NewMemory()
+	})
+}
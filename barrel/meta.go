@@ -2,14 +2,59 @@ package barrel
 
 import (
 	"context"
+	"time"
 
 	"github.com/projecteru2/minions/types"
 )
 
 // Meta .
 type Meta interface {
+	// ReserveIPforContainer reserves address for container ID, the request mark of address is consumed
+	// in the same write, so it's kept when the reservation fails
 	ReserveIPforContainer(ctx context.Context, address *types.ReservedAddress, ID string) error
 	IPIsReserved(ctx context.Context, address *types.ReservedAddress) (bool, error)
+	// RequestMarkIsPresent checks the request mark of the address without consuming it
+	RequestMarkIsPresent(ctx context.Context, request *types.ReserveRequest) (bool, error)
 	ConsumeRequestMarkIfPresent(ctx context.Context, request *types.ReserveRequest) (bool, error)
+	// AquireIfReserved removes the reservation of address along with its entry in the container record,
+	// returns false when address is not reserved. When address.ContainerID is not blank, only the reservation
+	// of that identity is acquired, otherwise it's filled with the identity of the acquired reservation
 	AquireIfReserved(ctx context.Context, address *types.ReservedAddress) (bool, error)
+
+	// ListReservedAddresses lists reserved addresses of the pool, lists all pools when poolID is blank
+	ListReservedAddresses(ctx context.Context, poolID string) ([]types.ReservedAddress, error)
+	// GetContainerInfo fills info by info.ID, returns false when not found
+	GetContainerInfo(ctx context.Context, info *types.ContainerInfo) (bool, error)
+	// MarkReserveRequest marks the address to be reserved when its container leaves
+	MarkReserveRequest(ctx context.Context, request *types.ReserveRequest) error
+	// ListReserveRequests lists request marks of the pool, lists all pools when poolID is blank
+	ListReserveRequests(ctx context.Context, poolID string) ([]types.ReserveRequest, error)
+
+	// ListContainerInfos lists all container records
+	ListContainerInfos(ctx context.Context) ([]types.ContainerInfo, error)
+	// RemoveContainerAddress removes address from the record of container address.ContainerID
+	RemoveContainerAddress(ctx context.Context, address *types.ReservedAddress) error
+	// ReleaseReservedAddress removes the reservation of address and the record of its container,
+	// returns false when address is not reserved
+	ReleaseReservedAddress(ctx context.Context, address *types.ReservedAddress) (bool, error)
+	// ReleaseExpiredAddresses removes reservations expired before now, returns the removed ones
+	ReleaseExpiredAddresses(ctx context.Context, now time.Time) ([]types.ReservedAddress, error)
+
+	// PutEndpoint saves the endpoint record, it can be got by ID and by each of its addresses
+	PutEndpoint(ctx context.Context, endpoint *types.Endpoint) error
+	// GetEndpoint fills endpoint by endpoint.ID, returns false when not found
+	GetEndpoint(ctx context.Context, endpoint *types.Endpoint) (bool, error)
+	// GetEndpointByAddress fills endpoint by endpoint.PoolID and endpoint.Address, which are of either IPv4 or IPv6,
+	// returns false when not found
+	GetEndpointByAddress(ctx context.Context, endpoint *types.Endpoint) (bool, error)
+	// DeleteEndpoint removes the endpoint record got before
+	DeleteEndpoint(ctx context.Context, endpoint *types.Endpoint) error
+
+	// PutSubPool restricts the pool to subPool.CIDR, returns types.ErrSubPoolConflict
+	// when the pool is restricted to another CIDR
+	PutSubPool(ctx context.Context, subPool *types.SubPool) error
+	// GetSubPool fills subPool by subPool.PoolID, returns false when the pool is not restricted
+	GetSubPool(ctx context.Context, subPool *types.SubPool) (bool, error)
+	// DeleteSubPool lifts the restriction of the pool
+	DeleteSubPool(ctx context.Context, subPool *types.SubPool) error
 }
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dockerClient "github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/projecteru2/minions/admin"
+	calDriver "github.com/projecteru2/minions/driver/calico"
+	"github.com/projecteru2/minions/types"
+)
+
+func commands() []*cli.Command {
+	poolFlag := &cli.StringFlag{
+		Name:  "pool",
+		Usage: "calico pool name, blank for all pools",
+	}
+	requiredPoolFlag := &cli.StringFlag{
+		Name:     "pool",
+		Usage:    "calico pool name",
+		Required: true,
+	}
+	return []*cli.Command{
+		{
+			Name:  "ls",
+			Usage: "list barrel resources",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "reserved",
+					Usage:  "list reserved ips",
+					Flags:  []cli.Flag{poolFlag},
+					Action: listReserved,
+				},
+				{
+					Name:   "marks",
+					Usage:  "list reserve request marks",
+					Flags:  []cli.Flag{poolFlag},
+					Action: listMarks,
+				},
+				{
+					Name:   "pools",
+					Usage:  "list calico pools and their docker networks",
+					Action: listPools,
+				},
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "inspect barrel resources",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "address",
+					Usage:     "inspect a reserved ip",
+					ArgsUsage: "<ip>",
+					Flags:     []cli.Flag{poolFlag},
+					Action:    inspectAddress,
+				},
+				{
+					Name:      "container",
+					Usage:     "inspect ips reserved by a container",
+					ArgsUsage: "<container identity>",
+					Action:    inspectContainer,
+				},
+			},
+		},
+		{
+			Name:      "reserve",
+			Usage:     "reserve a free ip for a container",
+			ArgsUsage: "<ip>",
+			Flags: []cli.Flag{
+				requiredPoolFlag,
+				&cli.StringFlag{
+					Name:     "container",
+					Usage:    "container identity to reserve for, the id, name or label value according to --identity of the plugin",
+					Required: true,
+				},
+				&cli.DurationFlag{
+					Name:  "ttl",
+					Usage: "lifetime of the reservation, 0 means never expire",
+				},
+			},
+			Action: reserveAddress,
+		},
+		{
+			Name:      "mark",
+			Usage:     "mark an ip in use to be reserved when its container leaves",
+			ArgsUsage: "<ip>",
+			Flags:     []cli.Flag{requiredPoolFlag},
+			Action:    markAddress,
+		},
+		{
+			Name:      "unmark",
+			Usage:     "cancel the reserve request mark of an ip",
+			ArgsUsage: "<ip>",
+			Flags:     []cli.Flag{requiredPoolFlag},
+			Action:    unmarkAddress,
+		},
+		{
+			Name:  "reconcile",
+			Usage: "report drifts between barrel, calico IPAM and docker",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "fix",
+					Usage: "fix the drifts found",
+				},
+			},
+			Action: reconcile,
+		},
+		{
+			Name:      "release",
+			Usage:     "release a reserved ip back to calico",
+			ArgsUsage: "<ip>",
+			Flags:     []cli.Flag{poolFlag},
+			Action:    releaseAddress,
+		},
+	}
+}
+
+func newAdmin(c *cli.Context) (*admin.Admin, error) {
+	calicoCli, barrelMeta, err := newCalicoClients(c)
+	if err != nil {
+		return nil, err
+	}
+	dockerCli, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while attempting to instantiate docker client from env")
+	}
+	return admin.NewAdmin(calicoCli, calDriver.NewPoolCache(calicoCli, 0), dockerCli, barrelMeta)
+}
+
+func addressFromArgs(c *cli.Context) (*types.ReservedAddress, error) {
+	if c.NArg() != 1 {
+		return nil, errors.Errorf("%s requires exactly one ip", c.Command.Name)
+	}
+	return &types.ReservedAddress{
+		PoolID:  c.String("pool"),
+		Address: c.Args().First(),
+	}, nil
+}
+
+func listReserved(c *cli.Context) error {
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	addresses, err := a.ListReservedAddresses(c.Context, c.String("pool"))
+	if err != nil {
+		return err
+	}
+	return printAddresses(addresses...)
+}
+
+func inspectAddress(c *cli.Context) error {
+	address, err := addressFromArgs(c)
+	if err != nil {
+		return err
+	}
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	reserved, err := a.GetReservedAddress(c.Context, address)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return errors.Errorf("ip %s is not reserved", address.Address)
+	}
+	return printAddresses(*address)
+}
+
+func reserveAddress(c *cli.Context) error {
+	address, err := addressFromArgs(c)
+	if err != nil {
+		return err
+	}
+	if ttl := c.Duration("ttl"); ttl > 0 {
+		address.ExpireAt = time.Now().Add(ttl)
+	}
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	if err = a.ReserveAddress(c.Context, address, c.String("container")); err != nil {
+		return err
+	}
+	return printAddresses(*address)
+}
+
+func inspectContainer(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.Errorf("%s requires exactly one container", c.Command.Name)
+	}
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	info, found, err := a.GetContainerInfo(c.Context, c.Args().First())
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("container %s reserves no ip", c.Args().First())
+	}
+	return printAddresses(info.Addresses...)
+}
+
+func listPools(c *cli.Context) error {
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	pools, err := a.ListPools(c.Context)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tCIDR\tNETWORKS")
+	for _, pool := range pools {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", pool.Name, pool.CIDR, strings.Join(pool.NetworkIDs, ","))
+	}
+	return w.Flush()
+}
+
+func releaseAddress(c *cli.Context) error {
+	address, err := addressFromArgs(c)
+	if err != nil {
+		return err
+	}
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	released, err := a.ReleaseReservedAddress(c.Context, address)
+	if err != nil {
+		return err
+	}
+	if !released {
+		return errors.Errorf("ip %s is not reserved", address.Address)
+	}
+	fmt.Printf("ip %s released\n", address.Address)
+	return nil
+}
+
+func listMarks(c *cli.Context) error {
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	requests, err := a.ListReserveRequests(c.Context, c.String("pool"))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tADDRESS")
+	for _, request := range requests {
+		fmt.Fprintf(w, "%s\t%s\n", request.PoolID, request.Address)
+	}
+	return w.Flush()
+}
+
+func markAddress(c *cli.Context) error {
+	address, err := addressFromArgs(c)
+	if err != nil {
+		return err
+	}
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	if err = a.MarkReserveRequest(c.Context, &types.ReserveRequest{ReservedAddress: *address}); err != nil {
+		return err
+	}
+	fmt.Printf("ip %s marked\n", address.Address)
+	return nil
+}
+
+func unmarkAddress(c *cli.Context) error {
+	address, err := addressFromArgs(c)
+	if err != nil {
+		return err
+	}
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	canceled, err := a.CancelReserveRequest(c.Context, &types.ReserveRequest{ReservedAddress: *address})
+	if err != nil {
+		return err
+	}
+	if !canceled {
+		return errors.Errorf("ip %s is not marked", address.Address)
+	}
+	fmt.Printf("ip %s unmarked\n", address.Address)
+	return nil
+}
+
+func reconcile(c *cli.Context) error {
+	a, err := newAdmin(c)
+	if err != nil {
+		return err
+	}
+	drifts, err := a.Reconcile(c.Context, c.Bool("fix"))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tPOOL\tADDRESS\tCONTAINER\tFIXED\tERROR")
+	for _, drift := range drifts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%s\n", drift.Kind, drift.PoolID, drift.Address, drift.ContainerID, drift.Fixed, drift.Error)
+	}
+	return w.Flush()
+}
+
+func printAddresses(addresses ...types.ReservedAddress) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tADDRESS\tCONTAINER\tEXPIRE AT")
+	for _, address := range addresses {
+		expireAt := "never"
+		if !address.ExpireAt.IsZero() {
+			expireAt = address.ExpireAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", address.PoolID, address.Address, address.ContainerID, expireAt)
+	}
+	return w.Flush()
+}
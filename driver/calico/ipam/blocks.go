@@ -0,0 +1,156 @@
+package ipam
+
+import (
+	"context"
+	"math/big"
+	"net"
+
+	"github.com/pkg/errors"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/libcalico-go/lib/backend/model"
+	cerrors "github.com/projectcalico/libcalico-go/lib/errors"
+	caliconet "github.com/projectcalico/libcalico-go/lib/net"
+	osutils "github.com/projectcalico/libnetwork-plugin/utils/os"
+)
+
+// calicoBackend is implemented by the calico v3 client, which exposes its backend to consumers reading raw models.
+// libcalico reports the allocation state of addresses with plain errors only, so it's read from the blocks instead.
+type calicoBackend interface {
+	Backend() bapi.Client
+}
+
+// allocationBlock is an IPAM block, a nil block is not allocated yet and has no address assigned
+type allocationBlock struct {
+	*model.AllocationBlock
+}
+
+// assigned returns whether ip of the block is assigned
+func (b allocationBlock) assigned(ip net.IP) bool {
+	if b.AllocationBlock == nil || !b.CIDR.Contains(ip) {
+		return false
+	}
+	ordinal := new(big.Int).Sub(ipToInt(ip), ipToInt(b.CIDR.IP))
+	if !ordinal.IsInt64() || ordinal.Int64() >= int64(len(b.Allocations)) {
+		return false
+	}
+	return b.Allocations[ordinal.Int64()] != nil
+}
+
+// assignedIPs returns the assigned addresses of the block
+func (b allocationBlock) assignedIPs() []net.IP {
+	if b.AllocationBlock == nil {
+		return nil
+	}
+	var ips []net.IP
+	for ordinal, allocation := range b.Allocations {
+		if allocation != nil {
+			ips = append(ips, addToIP(b.CIDR.IP, big.NewInt(int64(ordinal))))
+		}
+	}
+	return ips
+}
+
+// blockCIDR returns the CIDR of the block of pool containing ip
+func blockCIDR(pool *apiv3.IPPool, ip net.IP) caliconet.IPNet {
+	version, bits := 6, 128
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		version, bits = 4, 32
+	}
+	mask := net.CIDRMask(poolBlockSize(*pool, version), bits)
+	return caliconet.IPNet{IPNet: net.IPNet{IP: ip.Mask(mask), Mask: mask}}
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return new(big.Int).SetBytes(ip)
+}
+
+func (c CalicoIPAM) backend() (bapi.Client, error) {
+	backend, ok := c.cliv3.(calicoBackend)
+	if !ok {
+		return nil, errors.New("Calico client doesn't expose its backend")
+	}
+	return backend.Backend(), nil
+}
+
+// poolOfIP returns the pool containing ip, returns false when not found
+func (c CalicoIPAM) poolOfIP(ctx context.Context, ip net.IP) (*apiv3.IPPool, bool, error) {
+	return c.pools.Find(ctx, func(pool *apiv3.IPPool) bool {
+		_, cidr, err := caliconet.ParseCIDR(pool.Spec.CIDR)
+		return err == nil && cidr.Contains(ip)
+	})
+}
+
+// getBlock reads the block of pool containing ip
+func (c CalicoIPAM) getBlock(ctx context.Context, pool *apiv3.IPPool, ip net.IP) (allocationBlock, error) {
+	return c.getBlockByCIDR(ctx, blockCIDR(pool, ip))
+}
+
+// getBlockByCIDR reads the block of cidr, the block is nil when it's not allocated yet
+func (c CalicoIPAM) getBlockByCIDR(ctx context.Context, cidr caliconet.IPNet) (allocationBlock, error) {
+	backend, err := c.backend()
+	if err != nil {
+		return allocationBlock{}, err
+	}
+	kv, err := backend.Get(ctx, model.BlockKey{CIDR: cidr}, "")
+	if err != nil {
+		if _, ok := err.(cerrors.ErrorResourceDoesNotExist); ok {
+			return allocationBlock{}, nil
+		}
+		return allocationBlock{}, err
+	}
+	block, ok := kv.Value.(*model.AllocationBlock)
+	if !ok {
+		return allocationBlock{}, errors.Errorf("Unexpected value of block %s", kv.Key)
+	}
+	return allocationBlock{block}, nil
+}
+
+// Assignment is an address assigned in calico IPAM
+type Assignment struct {
+	Pool    string
+	Address string
+}
+
+// HostAssignments lists addresses assigned in the blocks affine to this host
+func (c CalicoIPAM) HostAssignments(ctx context.Context) ([]Assignment, error) {
+	hostname, err := osutils.GetHostname()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := c.backend()
+	if err != nil {
+		return nil, err
+	}
+	affinities, err := backend.List(ctx, model.BlockAffinityListOptions{Host: hostname}, "")
+	if err != nil {
+		return nil, err
+	}
+	var assignments []Assignment
+	for _, kv := range affinities.KVPairs {
+		key, ok := kv.Key.(model.BlockAffinityKey)
+		if !ok {
+			continue
+		}
+		pool, found, err := c.poolOfIP(ctx, key.CIDR.IP)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			// blocks of deleted pools are left to calico
+			continue
+		}
+		block, err := c.getBlockByCIDR(ctx, key.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range block.assignedIPs() {
+			assignments = append(assignments, Assignment{Pool: pool.Name, Address: ip.String()})
+		}
+	}
+	return assignments, nil
+}
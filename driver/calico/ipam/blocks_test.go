@@ -0,0 +1,200 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	cerrors "github.com/projectcalico/libcalico-go/lib/errors"
+	calicoipam "github.com/projectcalico/libcalico-go/lib/ipam"
+	caliconet "github.com/projectcalico/libcalico-go/lib/net"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	calDriver "github.com/projecteru2/minions/driver/calico"
+)
+
+// fakeCalico keeps pools and blocks in memory, assignIP decides the result of AssignIP
+type fakeCalico struct {
+	clientv3.Interface
+
+	mu       sync.Mutex
+	pools    []apiv3.IPPool
+	blocks   map[string]*model.AllocationBlock
+	getErr   error
+	assignIP func(ip net.IP) error
+	assigned []string
+}
+
+func newFakeCalico(pools ...apiv3.IPPool) *fakeCalico {
+	return &fakeCalico{pools: pools, blocks: make(map[string]*model.AllocationBlock)}
+}
+
+type fakeIPPools struct {
+	clientv3.IPPoolInterface
+	*fakeCalico
+}
+
+type fakeIPAMClient struct {
+	calicoipam.Interface
+	*fakeCalico
+}
+
+type fakeBackend struct {
+	bapi.Client
+	*fakeCalico
+}
+
+func (f *fakeCalico) IPPools() clientv3.IPPoolInterface { return fakeIPPools{fakeCalico: f} }
+func (f *fakeCalico) IPAM() calicoipam.Interface        { return fakeIPAMClient{fakeCalico: f} }
+func (f *fakeCalico) Backend() bapi.Client              { return fakeBackend{fakeCalico: f} }
+
+func (f fakeIPPools) List(ctx context.Context, opts options.ListOptions) (*apiv3.IPPoolList, error) {
+	return &apiv3.IPPoolList{Items: f.pools}, nil
+}
+
+func (f fakeBackend) Get(ctx context.Context, key model.Key, revision string) (*model.KVPair, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	block, ok := f.blocks[key.(model.BlockKey).CIDR.String()]
+	if !ok {
+		return nil, cerrors.ErrorResourceDoesNotExist{Identifier: key}
+	}
+	return &model.KVPair{Key: key, Value: block}, nil
+}
+
+func (f fakeIPAMClient) AssignIP(ctx context.Context, args calicoipam.AssignIPArgs) error {
+	if f.assignIP != nil {
+		if err := f.assignIP(args.IP.IP); err != nil {
+			return err
+		}
+	}
+	f.allocate(args.IP.IP.String())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.assigned = append(f.assigned, args.IP.IP.String())
+	return nil
+}
+
+// addBlock adds an empty block
+func (f *fakeCalico) addBlock(cidr string) {
+	_, ipNet, _ := caliconet.ParseCIDR(cidr)
+	ones, bits := ipNet.Mask.Size()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[ipNet.String()] = &model.AllocationBlock{CIDR: *ipNet, Allocations: make([]*int, 1<<uint(bits-ones))}
+}
+
+// allocate marks ips assigned in their blocks
+func (f *fakeCalico) allocate(ips ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, address := range ips {
+		ip := net.ParseIP(address)
+		for _, block := range f.blocks {
+			if block.CIDR.Contains(ip) {
+				ordinal := ipToInt(ip).Int64() - ipToInt(block.CIDR.IP).Int64()
+				block.Allocations[ordinal] = new(int)
+			}
+		}
+	}
+}
+
+func newFakeIPAM(client *fakeCalico) *CalicoIPAM {
+	return NewCalicoIPAM(client, calDriver.NewPoolCache(client, time.Minute))
+}
+
+func TestIPIsAssigned(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeCalico(newPool("p4", "10.0.0.0/16", 26, nil), newPool("p6", "fd00::/64", 122, nil))
+	client.addBlock("10.0.0.0/26")
+	client.addBlock("fd00::/122")
+	client.allocate("10.0.0.1", "fd00::1")
+	c := newFakeIPAM(client)
+
+	for address, want := range map[string]bool{
+		"10.0.0.1":  true,
+		"10.0.0.2":  false,
+		"10.0.1.1":  false, // block not allocated yet
+		"10.1.0.1":  false, // out of pools
+		"fd00::1":   true,
+		"fd00::2":   false,
+		"fd00:1::1": false,
+	} {
+		assigned, err := c.IPIsAssigned(ctx, address)
+		require.NoError(t, err, address)
+		assert.Equal(t, want, assigned, address)
+	}
+
+	_, err := c.IPIsAssigned(ctx, "10.0.0")
+	assert.Error(t, err)
+	// datastore errors aren't taken for unassigned addresses
+	client.getErr = errors.New("etcd unavailable")
+	_, err = c.IPIsAssigned(ctx, "10.0.0.1")
+	assert.EqualError(t, err, "etcd unavailable")
+}
+
+func TestAssignFromRange(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeCalico(newPool("p4", "10.0.0.0/16", 26, nil))
+	client.addBlock("10.0.0.0/26")
+	client.allocate("10.0.0.0", "10.0.0.1", "10.0.0.3")
+	c := newFakeIPAM(client)
+	_, ipRange, _ := net.ParseCIDR("10.0.0.0/30")
+
+	// only the free address is tried
+	ip, err := c.AssignFromRange(ctx, ipRange)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+	assert.Equal(t, []string{"10.0.0.2"}, client.assigned)
+
+	_, err = c.AssignFromRange(ctx, ipRange)
+	assert.Error(t, err, "the range is full")
+
+	// addresses taken by others meanwhile are skipped
+	_, ipRange, _ = net.ParseCIDR("10.0.0.4/31")
+	client.assignIP = func(ip net.IP) error {
+		if ip.String() == "10.0.0.4" {
+			client.allocate("10.0.0.4")
+			return errors.New("Address already assigned in block")
+		}
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		client.mu.Lock()
+		client.blocks["10.0.0.0/26"].Allocations[4] = nil
+		client.blocks["10.0.0.0/26"].Allocations[5] = nil
+		client.mu.Unlock()
+		ip, err = c.AssignFromRange(ctx, ipRange)
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.5", ip.String())
+	}
+
+	// other errors are returned
+	_, ipRange, _ = net.ParseCIDR("10.0.0.8/30")
+	client.assignIP = func(ip net.IP) error {
+		return errors.New("strict affinity")
+	}
+	_, err = c.AssignFromRange(ctx, ipRange)
+	assert.EqualError(t, err, "strict affinity")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.AssignFromRange(canceled, ipRange)
+	assert.Equal(t, context.Canceled, err)
+
+	_, ipRange, _ = net.ParseCIDR("10.1.0.0/30")
+	_, err = c.AssignFromRange(ctx, ipRange)
+	assert.Error(t, err, "out of pools")
+}
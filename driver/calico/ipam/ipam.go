@@ -9,8 +9,8 @@ import (
 	"github.com/projectcalico/libcalico-go/lib/clientv3"
 	calicoipam "github.com/projectcalico/libcalico-go/lib/ipam"
 	caliconet "github.com/projectcalico/libcalico-go/lib/net"
-	"github.com/projectcalico/libcalico-go/lib/options"
 	osutils "github.com/projectcalico/libnetwork-plugin/utils/os"
+	calDriver "github.com/projecteru2/minions/driver/calico"
 	"github.com/projecteru2/minions/types"
 	log "github.com/sirupsen/logrus"
 )
@@ -18,15 +18,16 @@ import (
 // CalicoIPAM .
 type CalicoIPAM struct {
 	cliv3 clientv3.Interface
+	pools *calDriver.PoolCache
 }
 
 // NewCalicoIPAM .
-func NewCalicoIPAM(cliv3 clientv3.Interface) *CalicoIPAM {
-	return &CalicoIPAM{cliv3}
+func NewCalicoIPAM(cliv3 clientv3.Interface, pools *calDriver.PoolCache) *CalicoIPAM {
+	return &CalicoIPAM{cliv3, pools}
 }
 
 // AssignIP .
-func (c CalicoIPAM) AssignIP(address string) (caliconet.IP, error) {
+func (c CalicoIPAM) AssignIP(ctx context.Context, address string) (caliconet.IP, error) {
 	var err error
 
 	var hostname string
@@ -39,20 +40,22 @@ func (c CalicoIPAM) AssignIP(address string) (caliconet.IP, error) {
 	// We'll return an error if the address isn't in a Calico pool, but we don't care which pool it's in
 	// (i.e. it doesn't need to match the subnet from the docker network).
 	log.Debugln("Reserving a specific address in Calico pools")
-	ipArgs := calicoipam.AssignIPArgs{
-		IP:       caliconet.IP{IP: ip},
-		Hostname: hostname,
-	}
-
-	if err = c.cliv3.IPAM().AssignIP(context.Background(), ipArgs); err != nil {
-		log.Errorf("IP assignment error, data: %+v\n", ipArgs)
+	if err = c.assignIP(ctx, hostname, ip); err != nil {
+		log.Errorf("IP assignment error, ip: %v, hostname: %v", ip, hostname)
 		return caliconet.IP{}, err
 	}
 	return caliconet.IP{IP: ip}, nil
 }
 
+func (c CalicoIPAM) assignIP(ctx context.Context, hostname string, ip net.IP) error {
+	return c.cliv3.IPAM().AssignIP(ctx, calicoipam.AssignIPArgs{
+		IP:       caliconet.IP{IP: ip},
+		Hostname: hostname,
+	})
+}
+
 // AutoAssign .
-func (c CalicoIPAM) AutoAssign(poolName string) (caliconet.IP, error) {
+func (c CalicoIPAM) AutoAssign(ctx context.Context, poolName string) (caliconet.IP, error) {
 	var err error
 
 	// No address requested, so auto assign from our pools.
@@ -80,7 +83,7 @@ func (c CalicoIPAM) AutoAssign(poolName string) (caliconet.IP, error) {
 		var version int
 
 		var ipPool *apiv3.IPPool
-		if ipPool, err = c.GetIPPool(poolName); err != nil {
+		if ipPool, err = c.GetIPPool(ctx, poolName); err != nil {
 			log.Errorf("Invalid Pool - %v", poolName)
 			return caliconet.IP{}, err
 		}
@@ -109,7 +112,7 @@ func (c CalicoIPAM) AutoAssign(poolName string) (caliconet.IP, error) {
 	var IPsV4 []caliconet.IP
 	var IPsV6 []caliconet.IP
 	if IPsV4, IPsV6, err = c.cliv3.IPAM().AutoAssign(
-		context.Background(),
+		ctx,
 		calicoipam.AutoAssignArgs{
 			Num4:      numIPv4,
 			Num6:      numIPv6,
@@ -132,16 +135,23 @@ func (c CalicoIPAM) AutoAssign(poolName string) (caliconet.IP, error) {
 }
 
 // GetIPPool .
-func (c CalicoIPAM) GetIPPool(poolName string) (*apiv3.IPPool, error) {
-	return c.cliv3.IPPools().Get(context.Background(), poolName, options.GetOptions{})
+func (c CalicoIPAM) GetIPPool(ctx context.Context, poolName string) (*apiv3.IPPool, error) {
+	pool, found, err := c.pools.Get(ctx, poolName)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.Errorf("Pool %s not found", poolName)
+	}
+	return pool, nil
 }
 
 // ReleaseIP .
-func (c CalicoIPAM) ReleaseIP(poolName string, address string) error {
+func (c CalicoIPAM) ReleaseIP(ctx context.Context, poolName string, address string) error {
 	ip := caliconet.IP{IP: net.ParseIP(address)}
 	// Unassign the address.  This handles the address already being unassigned
 	// in which case it is a no-op.
-	if _, err := c.cliv3.IPAM().ReleaseIPs(context.Background(), []caliconet.IP{ip}); err != nil {
+	if _, err := c.cliv3.IPAM().ReleaseIPs(ctx, []caliconet.IP{ip}); err != nil {
 		log.Errorf("IP releasing error, ip: %v", ip)
 		return err
 	}
@@ -149,16 +159,38 @@ func (c CalicoIPAM) ReleaseIP(poolName string, address string) error {
 	return nil
 }
 
+// IPIsAssigned returns whether address is assigned in calico IPAM, addresses out of pools are not
+func (c CalicoIPAM) IPIsAssigned(ctx context.Context, address string) (bool, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false, errors.Errorf("Invalid IP - %v", address)
+	}
+	pool, found, err := c.poolOfIP(ctx, ip)
+	if err != nil || !found {
+		return false, err
+	}
+	block, err := c.getBlock(ctx, pool, ip)
+	if err != nil {
+		return false, err
+	}
+	return block.assigned(ip), nil
+}
+
 // IPPools .
-func (c CalicoIPAM) IPPools() (*apiv3.IPPoolList, error) {
-	return c.cliv3.IPPools().List(context.Background(), options.ListOptions{})
+func (c CalicoIPAM) IPPools(ctx context.Context) (*apiv3.IPPoolList, error) {
+	pools, err := c.pools.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &apiv3.IPPoolList{Items: pools}, nil
 }
 
 // RequestPool .
-func (c CalicoIPAM) RequestPool(cidr string) (*types.Pool, error) {
+func (c CalicoIPAM) RequestPool(ctx context.Context, cidr string) (*types.Pool, error) {
 	var (
 		ipNet *caliconet.IPNet
-		pools *apiv3.IPPoolList
+		pool  *apiv3.IPPool
+		found bool
 		err   error
 	)
 
@@ -167,25 +199,23 @@ func (c CalicoIPAM) RequestPool(cidr string) (*types.Pool, error) {
 		return nil, err
 	}
 
-	if pools, err = c.IPPools(); err != nil {
+	if pool, found, err = c.pools.GetByCIDR(ctx, ipNet.String()); err != nil {
 		log.Errorf("[CalicoDriver::RequestPool] Get pools error, %v", err)
 		return nil, err
 	}
 
-	for _, p := range pools.Items {
-		if p.Spec.CIDR == ipNet.String() {
-			var gateway string
-			if ipNet.Version() == 4 {
-				gateway = "0.0.0.0/0"
-			} else {
-				gateway = "::/0"
-			}
-			return &types.Pool{
-				CIDR:    p.Spec.CIDR,
-				Name:    p.Name,
-				Gateway: gateway,
-			}, nil
+	if found {
+		var gateway string
+		if ipNet.Version() == 4 {
+			gateway = "0.0.0.0/0"
+		} else {
+			gateway = "::/0"
 		}
+		return &types.Pool{
+			CIDR:    pool.Spec.CIDR,
+			Name:    pool.Name,
+			Gateway: gateway,
+		}, nil
 	}
 
 	return nil, errors.Errorf("The requested subnet(%s) didn't match any CIDR of a "+
@@ -193,7 +223,7 @@ func (c CalicoIPAM) RequestPool(cidr string) (*types.Pool, error) {
 }
 
 // RequestPools .
-func (c CalicoIPAM) RequestPools(cidrs []string) ([]*types.Pool, error) {
+func (c CalicoIPAM) RequestPools(ctx context.Context, cidrs []string) ([]*types.Pool, error) {
 	var (
 		ipNets = make(map[string]*caliconet.IPNet)
 		pools  *apiv3.IPPoolList
@@ -208,7 +238,7 @@ func (c CalicoIPAM) RequestPools(cidrs []string) ([]*types.Pool, error) {
 		}
 		ipNets[ipNet.String()] = ipNet
 	}
-	if pools, err = c.IPPools(); err != nil {
+	if pools, err = c.IPPools(ctx); err != nil {
 		log.Errorf("[CalicoDriver::RequestPool] Get pools error, %v", err)
 		return nil, err
 	}
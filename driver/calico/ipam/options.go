@@ -0,0 +1,157 @@
+package ipam
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	caliconet "github.com/projectcalico/libcalico-go/lib/net"
+	"github.com/projectcalico/libcalico-go/lib/selector"
+
+	"github.com/projecteru2/minions/types"
+)
+
+const (
+	// PoolOptionName selects the calico pool by name, e.g. --ipam-opt pool=my-pool
+	PoolOptionName = "pool"
+	// PoolOptionLabelSelector selects calico pools by their own metadata labels, e.g. --ipam-opt pool-selector="zone == 'a'".
+	// It's not the node selector of calico pools, which pools of this calico version don't have
+	PoolOptionLabelSelector = "pool-selector"
+	// PoolOptionBlockSize selects calico pools by block size, e.g. --ipam-opt block-size=26
+	PoolOptionBlockSize = "block-size"
+
+	// block sizes calico gives pools created without one
+	defaultBlockSizeV4 = 26
+	defaultBlockSizeV6 = 122
+)
+
+// PoolOptions selects the calico pool of a docker network by ipam options
+type PoolOptions struct {
+	Name          string
+	LabelSelector string
+	BlockSize     int
+}
+
+// ParsePoolOptions parses ipam options of RequestPool, unknown options are refused
+func ParsePoolOptions(options map[string]string) (PoolOptions, error) {
+	var opts PoolOptions
+	for key, value := range options {
+		value = strings.TrimSpace(value)
+		switch key {
+		case PoolOptionName:
+			opts.Name = value
+		case PoolOptionLabelSelector:
+			if _, err := selector.Parse(value); err != nil {
+				return opts, errors.Wrapf(err, "Invalid ipam option %s(%s)", key, value)
+			}
+			opts.LabelSelector = value
+		case PoolOptionBlockSize:
+			size, err := strconv.Atoi(value)
+			if err != nil || size <= 0 || size > 128 {
+				return opts, errors.Errorf("Invalid ipam option %s(%s)", key, value)
+			}
+			opts.BlockSize = size
+		default:
+			return opts, errors.Errorf("Unsupported ipam option %s, supported options are %s, %s and %s",
+				key, PoolOptionName, PoolOptionLabelSelector, PoolOptionBlockSize)
+		}
+	}
+	return opts, nil
+}
+
+// IsEmpty returns whether no option is given
+func (o PoolOptions) IsEmpty() bool {
+	return o.Name == "" && o.LabelSelector == "" && o.BlockSize == 0
+}
+
+// SelectPool returns the only enabled pool matching options, the CIDR if it's not blank
+// and the IP version, it's an error when none or more than one pool match
+func (c CalicoIPAM) SelectPool(ctx context.Context, cidr string, v6 bool, opts PoolOptions) (*types.Pool, error) {
+	pools, err := c.pools.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := matchPools(pools, cidr, v6, opts)
+	if err != nil {
+		return nil, err
+	}
+	switch len(matched) {
+	case 0:
+		return nil, errors.Errorf("No calico pool matches ipam options %+v and subnet(%s)", opts, cidr)
+	case 1:
+		gateway := "0.0.0.0/0"
+		if _, ipNet, _ := caliconet.ParseCIDR(matched[0].Spec.CIDR); ipNet.Version() == 6 {
+			gateway = "::/0"
+		}
+		return &types.Pool{
+			CIDR:    matched[0].Spec.CIDR,
+			Name:    matched[0].Name,
+			Gateway: gateway,
+		}, nil
+	}
+	names := make([]string, 0, len(matched))
+	for _, pool := range matched {
+		names = append(names, pool.Name)
+	}
+	sort.Strings(names)
+	return nil, errors.Errorf("Calico pools %s all match ipam options %+v, choose one by %s option",
+		strings.Join(names, ","), opts, PoolOptionName)
+}
+
+func matchPools(pools []apiv3.IPPool, cidr string, v6 bool, opts PoolOptions) ([]apiv3.IPPool, error) {
+	var sel selector.Selector
+	if opts.LabelSelector != "" {
+		var err error
+		if sel, err = selector.Parse(opts.LabelSelector); err != nil {
+			return nil, err
+		}
+	}
+	if cidr != "" {
+		_, ipNet, err := caliconet.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		cidr = ipNet.String()
+	}
+
+	var matched []apiv3.IPPool
+	for _, pool := range pools {
+		if pool.Spec.Disabled {
+			continue
+		}
+		_, ipNet, err := caliconet.ParseCIDR(pool.Spec.CIDR)
+		if err != nil {
+			continue
+		}
+		if cidr != "" && ipNet.String() != cidr {
+			continue
+		}
+		if cidr == "" && (ipNet.Version() == 6) != v6 {
+			continue
+		}
+		if opts.Name != "" && pool.Name != opts.Name {
+			continue
+		}
+		if sel != nil && !sel.Evaluate(pool.Labels) {
+			continue
+		}
+		if opts.BlockSize != 0 && poolBlockSize(pool, ipNet.Version()) != opts.BlockSize {
+			continue
+		}
+		matched = append(matched, pool)
+	}
+	return matched, nil
+}
+
+func poolBlockSize(pool apiv3.IPPool, version int) int {
+	if pool.Spec.BlockSize != 0 {
+		return pool.Spec.BlockSize
+	}
+	if version == 6 {
+		return defaultBlockSizeV6
+	}
+	return defaultBlockSizeV4
+}
@@ -0,0 +1,71 @@
+package ipam
+
+import (
+	"testing"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPool(name, cidr string, blockSize int, labels map[string]string) apiv3.IPPool {
+	pool := apiv3.NewIPPool()
+	pool.Name = name
+	pool.Labels = labels
+	pool.Spec.CIDR = cidr
+	pool.Spec.BlockSize = blockSize
+	return *pool
+}
+
+func TestParsePoolOptions(t *testing.T) {
+	opts, err := ParsePoolOptions(nil)
+	assert.NoError(t, err)
+	assert.True(t, opts.IsEmpty())
+
+	opts, err = ParsePoolOptions(map[string]string{
+		PoolOptionName:          "p1",
+		PoolOptionLabelSelector: "zone == 'a'",
+		PoolOptionBlockSize:     " 28",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, PoolOptions{Name: "p1", LabelSelector: "zone == 'a'", BlockSize: 28}, opts)
+
+	_, err = ParsePoolOptions(map[string]string{PoolOptionBlockSize: "big"})
+	assert.Error(t, err)
+	_, err = ParsePoolOptions(map[string]string{PoolOptionLabelSelector: "zone =="})
+	assert.Error(t, err)
+	_, err = ParsePoolOptions(map[string]string{"gateway": "10.0.0.1"})
+	assert.Error(t, err)
+	// pools have no node selector, the label selector isn't taken for one
+	_, err = ParsePoolOptions(map[string]string{"selector": "zone == 'a'"})
+	assert.Error(t, err)
+}
+
+func TestMatchPools(t *testing.T) {
+	disabled := newPool("off", "10.2.0.0/16", 26, map[string]string{"zone": "a"})
+	disabled.Spec.Disabled = true
+	pools := []apiv3.IPPool{
+		newPool("a", "10.0.0.0/16", 26, map[string]string{"zone": "a"}),
+		newPool("b", "10.1.0.0/16", 28, map[string]string{"zone": "b"}),
+		newPool("v6", "fd00::/64", 0, map[string]string{"zone": "a"}),
+		disabled,
+	}
+	names := func(cidr string, v6 bool, opts PoolOptions) []string {
+		matched, err := matchPools(pools, cidr, v6, opts)
+		assert.NoError(t, err)
+		var result []string
+		for _, pool := range matched {
+			result = append(result, pool.Name)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"a", "b"}, names("", false, PoolOptions{}))
+	assert.Equal(t, []string{"b"}, names("", false, PoolOptions{Name: "b"}))
+	assert.Equal(t, []string{"a"}, names("", false, PoolOptions{LabelSelector: "zone == 'a'"}))
+	assert.Equal(t, []string{"v6"}, names("", true, PoolOptions{LabelSelector: "zone == 'a'"}))
+	assert.Equal(t, []string{"b"}, names("", false, PoolOptions{BlockSize: 28}))
+	assert.Equal(t, []string{"v6"}, names("", true, PoolOptions{BlockSize: defaultBlockSizeV6}))
+	assert.Equal(t, []string{"a"}, names("10.0.0.0/16", false, PoolOptions{LabelSelector: "has(zone)"}))
+	assert.Empty(t, names("10.0.0.0/16", false, PoolOptions{Name: "b"}))
+	assert.Empty(t, names("10.2.0.0/16", false, PoolOptions{Name: "off"}))
+}
@@ -0,0 +1,113 @@
+package ipam
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	caliconet "github.com/projectcalico/libcalico-go/lib/net"
+	osutils "github.com/projectcalico/libnetwork-plugin/utils/os"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxRangeAttempts bounds addresses tried by one assignment from a sub pool
+	maxRangeAttempts = 4096
+	// maxRangeConflicts bounds free addresses taken by concurrent assignments meanwhile
+	maxRangeConflicts = 16
+)
+
+// AssignFromRange assigns a free address in ipRange, which must be inside a calico pool.
+// Calico only auto assigns from whole pools, so addresses of the range are tried one by one
+// from a random offset, which spreads concurrent assignments of nodes.
+// Only the addresses free in their blocks are assigned, each block is read once.
+func (c CalicoIPAM) AssignFromRange(ctx context.Context, ipRange *net.IPNet) (caliconet.IP, error) {
+	hostname, err := osutils.GetHostname()
+	if err != nil {
+		return caliconet.IP{}, err
+	}
+	log.Infof("Assigning IP from sub pool %s", ipRange)
+
+	base := ipRange.IP.Mask(ipRange.Mask)
+	pool, found, err := c.poolOfIP(ctx, base)
+	if err != nil {
+		return caliconet.IP{}, err
+	}
+	if !found {
+		return caliconet.IP{}, errors.Errorf("Sub pool %s is not in a calico pool", ipRange)
+	}
+	ones, bits := ipRange.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	attempts := int64(maxRangeAttempts)
+	if size.IsInt64() && size.Int64() < attempts {
+		attempts = size.Int64()
+	}
+	offset := new(big.Int).Rand(rand.New(rand.NewSource(time.Now().UnixNano())), size) // nolint:gosec
+	blocks := make(map[string]allocationBlock)
+	conflicts := 0
+	for i := int64(0); i < attempts; i++ {
+		if err = ctx.Err(); err != nil {
+			return caliconet.IP{}, err
+		}
+		ip := addToIP(base, offset)
+		offset.Add(offset, big.NewInt(1))
+		offset.Mod(offset, size)
+
+		assigned, err := c.assignedInBlocks(ctx, blocks, pool, ip, false)
+		if err != nil {
+			return caliconet.IP{}, err
+		}
+		if assigned {
+			continue
+		}
+		if err = c.assignIP(ctx, hostname, ip); err == nil {
+			return caliconet.IP{IP: ip}, nil
+		}
+		// the address may be taken by others since the block is read
+		if assigned, checkErr := c.assignedInBlocks(ctx, blocks, pool, ip, true); checkErr != nil || !assigned {
+			log.Errorf("IP assignment error, ip: %v, hostname: %v", ip, hostname)
+			return caliconet.IP{}, err
+		}
+		conflicts++
+		if conflicts >= maxRangeConflicts {
+			return caliconet.IP{}, errors.Errorf("Too many concurrent assignments in sub pool %s", ipRange)
+		}
+	}
+	return caliconet.IP{}, errors.Errorf("No free address found in sub pool %s after %d attempts", ipRange, attempts)
+}
+
+// assignedInBlocks returns whether ip is assigned by its block, blocks caches the blocks read,
+// reload reads the block again
+func (c CalicoIPAM) assignedInBlocks(
+	ctx context.Context,
+	blocks map[string]allocationBlock,
+	pool *apiv3.IPPool,
+	ip net.IP,
+	reload bool,
+) (bool, error) {
+	key := blockCIDR(pool, ip).String()
+	block, ok := blocks[key]
+	if !ok || reload {
+		var err error
+		if block, err = c.getBlock(ctx, pool, ip); err != nil {
+			return false, err
+		}
+		blocks[key] = block
+	}
+	return block.assigned(ip), nil
+}
+
+// addToIP returns ip + offset, keeping the length of ip
+func addToIP(ip net.IP, offset *big.Int) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	sum := new(big.Int).Add(new(big.Int).SetBytes(ip), offset).Bytes()
+	result := make(net.IP, len(ip))
+	copy(result[len(result)-len(sum):], sum)
+	return result
+}
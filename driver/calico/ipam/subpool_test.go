@@ -0,0 +1,19 @@
+package ipam
+
+import (
+	"math/big"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddToIP(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.0.1.0/24")
+	assert.Equal(t, "10.0.1.0", addToIP(v4.IP, big.NewInt(0)).String())
+	assert.Equal(t, "10.0.1.255", addToIP(v4.IP, big.NewInt(255)).String())
+	assert.Equal(t, "10.0.2.1", addToIP(net.ParseIP("10.0.1.0"), big.NewInt(257)).String())
+
+	_, v6, _ := net.ParseCIDR("fd00::/120")
+	assert.Equal(t, "fd00::ff", addToIP(v6.IP, big.NewInt(255)).String())
+}
@@ -3,15 +3,53 @@ package network
 import (
 	"os"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-// IFPrefix .
+const (
+	// maxInterfaceNameLen is IFNAMSIZ without the trailing NUL
+	maxInterfaceNameLen = 15
+	// minEndpointIDLen is the least characters of endpoint ID in interface names, which keep them unique
+	minEndpointIDLen = 8
+	// tempInterfacePrefix names container side veths before libnetwork moves them into containers
+	tempInterfacePrefix = "temp"
+)
+
+// IFPrefix names interfaces inside containers, e.g. "eth" gets eth0
 var IFPrefix = "cali"
 
+// HostIFPrefix names host side veths, it must match the interface prefix of felix
+var HostIFPrefix = "cali"
+
 func init() { // nolint
 	if os.Getenv("CALICO_LIBNETWORK_IFPREFIX") != "" {
 		IFPrefix = os.Getenv("CALICO_LIBNETWORK_IFPREFIX")
 		log.Infof("Updated CALICO_LIBNETWORK_IFPREFIX to %s", IFPrefix)
 	}
+	if os.Getenv("CALICO_LIBNETWORK_HOST_IFPREFIX") != "" {
+		HostIFPrefix = os.Getenv("CALICO_LIBNETWORK_HOST_IFPREFIX")
+		log.Infof("Updated CALICO_LIBNETWORK_HOST_IFPREFIX to %s", HostIFPrefix)
+	}
+}
+
+// VethNamer names the host side veth, and the temporary container side veth of an endpoint
+type VethNamer func(endpointID string) (host, temp string)
+
+// NewVethNamer returns the VethNamer naming host side veths by prefix, which must match the interface prefix of felix.
+// Names are filled up to 15 characters with the endpoint ID, so prefix is at most 7 characters.
+func NewVethNamer(prefix string) (VethNamer, error) {
+	if prefix == "" || len(prefix) > maxInterfaceNameLen-minEndpointIDLen {
+		return nil, errors.Errorf("Interface prefix %q should be 1 to %d characters", prefix, maxInterfaceNameLen-minEndpointIDLen)
+	}
+	return func(endpointID string) (string, string) {
+		return interfaceName(prefix, endpointID), interfaceName(tempInterfacePrefix, endpointID)
+	}, nil
+}
+
+func interfaceName(prefix, endpointID string) string {
+	if n := maxInterfaceNameLen - len(prefix); len(endpointID) > n {
+		endpointID = endpointID[:n]
+	}
+	return prefix + endpointID
 }
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVethNamer(t *testing.T) {
+	endpointID := "0123456789abcdef0123456789abcdef"
+
+	names, err := NewVethNamer("cali")
+	require.NoError(t, err)
+	host, temp := names(endpointID)
+	assert.Equal(t, "cali0123456789a", host)
+	assert.Equal(t, "temp0123456789a", temp)
+
+	names, err = NewVethNamer("tap")
+	require.NoError(t, err)
+	host, temp = names(endpointID)
+	assert.Equal(t, "tap0123456789ab", host)
+	assert.Equal(t, "temp0123456789a", temp)
+
+	names, err = NewVethNamer("calico-")
+	require.NoError(t, err)
+	host, _ = names(endpointID)
+	assert.Equal(t, "calico-01234567", host)
+	assert.Len(t, host, maxInterfaceNameLen)
+
+	// short IDs are kept whole
+	host, temp = names("abc")
+	assert.Equal(t, "calico-abc", host)
+	assert.Equal(t, "tempabc", temp)
+
+	_, err = NewVethNamer("")
+	assert.Error(t, err)
+	_, err = NewVethNamer("calicoif")
+	assert.Error(t, err)
+}
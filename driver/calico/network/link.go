@@ -0,0 +1,101 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+)
+
+// hostVethAlias marks host side veths created by the driver, so they are told from veths of others
+// sharing the interface prefix, e.g. calico CNI
+const hostVethAlias = "minions"
+
+// ipVersions returns whether CIDRs of workload endpoint contain IPv4 and IPv6 networks
+func ipVersions(ipNetworks []string) (hasIPv4, hasIPv6 bool) {
+	for _, ipNetwork := range ipNetworks {
+		ip, _, err := net.ParseCIDR(ipNetwork)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+	return
+}
+
+// addLinkLocalAddr adds the link local address to the interface without duplicate address detection,
+// every host side veth gets the same one, which is fine within the scope of a link
+func addLinkLocalAddr(interfaceName, address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil || !ip.IsLinkLocalUnicast() || ip.To4() != nil {
+		return errors.Errorf("Invalid IPv6 link local address %s", address)
+	}
+	link, err := netlink.LinkByName(interfaceName)
+	if err != nil {
+		return err
+	}
+	err = netlink.AddrAdd(link, &netlink.Addr{
+		IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)},
+		Scope: syscall.RT_SCOPE_LINK,
+		Flags: syscall.IFA_F_NODAD,
+	})
+	if err == syscall.EEXIST {
+		return nil
+	}
+	return err
+}
+
+// markHostVeth sets hostVethAlias on the host side veth
+func markHostVeth(interfaceName string) error {
+	link, err := netlink.LinkByName(interfaceName)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetAlias(link, hostVethAlias)
+}
+
+// listHostVeths returns names of host side veths of the driver, see isHostVeth
+func listHostVeths(prefix string, known, foreign map[string]bool) ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, link := range links {
+		attrs := link.Attrs()
+		if link.Type() == "veth" && isHostVeth(attrs.Name, attrs.Alias, prefix, known, foreign) {
+			names = append(names, attrs.Name)
+		}
+	}
+	return names, nil
+}
+
+// isHostVeth returns whether the veth is created by the driver: it's marked by hostVethAlias,
+// or named in known, or named by prefix and an endpoint ID while not named in foreign.
+// Veths left by crashes before their weps are updated, or by versions without the alias, are only told by names.
+func isHostVeth(name, alias, prefix string, known, foreign map[string]bool) bool {
+	if alias == hostVethAlias || known[name] {
+		return true
+	}
+	return !foreign[name] && isEndpointInterfaceName(name, prefix)
+}
+
+// isEndpointInterfaceName returns whether name is prefix followed by an endpoint ID,
+// endpoint IDs are long hex strings, which always fill the name up
+func isEndpointInterfaceName(name, prefix string) bool {
+	if len(name) != maxInterfaceNameLen || !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	for _, c := range name[len(prefix):] {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPVersions(t *testing.T) {
+	hasIPv4, hasIPv6 := ipVersions(nil)
+	assert.False(t, hasIPv4)
+	assert.False(t, hasIPv6)
+
+	hasIPv4, hasIPv6 = ipVersions([]string{"10.0.0.1/32"})
+	assert.True(t, hasIPv4)
+	assert.False(t, hasIPv6)
+
+	hasIPv4, hasIPv6 = ipVersions([]string{"fd00::1/128", "invalid"})
+	assert.False(t, hasIPv4)
+	assert.True(t, hasIPv6)
+
+	hasIPv4, hasIPv6 = ipVersions([]string{"10.0.0.1/32", "fd00::1/128"})
+	assert.True(t, hasIPv4)
+	assert.True(t, hasIPv6)
+}
+
+func TestAddLinkLocalAddrRefusesInvalidAddress(t *testing.T) {
+	assert.Error(t, addLinkLocalAddr("cali0", "fd00::1"))
+	assert.Error(t, addLinkLocalAddr("cali0", "169.254.1.1"))
+	assert.Error(t, addLinkLocalAddr("cali0", "nexthop"))
+}
+
+func TestIsHostVeth(t *testing.T) {
+	known := map[string]bool{"tap0123": true}
+	foreign := map[string]bool{"cali89abcdef012": true}
+
+	for name, want := range map[string]bool{
+		"tap0123":         true,  // named by a wep
+		"cali0123456789a": true,  // left before the wep is updated
+		"cali89abcdef012": false, // of calico CNI
+		"cali0123456789":  false,
+		"cali0123456789g": false,
+		"eth0123456789ab": false,
+	} {
+		assert.Equal(t, want, isHostVeth(name, "", "cali", known, foreign), name)
+	}
+	assert.True(t, isHostVeth("veth0", hostVethAlias, "cali", known, foreign))
+	assert.True(t, isHostVeth("calico-0123abcd", "", "calico-", nil, nil))
+}
@@ -25,7 +25,6 @@ import (
 	wepname "github.com/projectcalico/libcalico-go/lib/names"
 	"github.com/projectcalico/libcalico-go/lib/options"
 	logutils "github.com/projectcalico/libnetwork-plugin/utils/log"
-	mathutils "github.com/projectcalico/libnetwork-plugin/utils/math"
 	"github.com/projectcalico/libnetwork-plugin/utils/netns"
 	osutils "github.com/projectcalico/libnetwork-plugin/utils/os"
 	netlink "github.com/vishvananda/netlink"
@@ -38,28 +37,46 @@ const (
 	DOCKER_LABEL_PREFIX       = "org.projectcalico.label."             // nolint
 	LABEL_POLL_TIMEOUT_ENVKEY = "CALICO_LIBNETWORK_LABEL_POLL_TIMEOUT" // nolint
 	CREATE_PROFILES_ENVKEY    = "CALICO_LIBNETWORK_CREATE_PROFILES"    // nolint
+	DELETE_PROFILES_ENVKEY    = "CALICO_LIBNETWORK_DELETE_PROFILES"    // nolint
 	LABEL_ENDPOINTS_ENVKEY    = "CALICO_LIBNETWORK_LABEL_ENDPOINTS"    // nolint
 	VETH_MTU_ENVKEY           = "CALICO_LIBNETWORK_VETH_MTU"           // nolint
 	NAMESPACE_ENVKEY          = "CALICO_LIBNETWORK_NAMESPACE"          // nolint
 )
 
+// Keys of EndpointInfo response
+const (
+	EndpointInfoWorkloadEndpoint = "WorkloadEndpoint"
+	EndpointInfoInterfaceName    = "InterfaceName"
+	EndpointInfoMAC              = "MAC"
+	EndpointInfoIPNetworks       = "IPNetworks"
+	EndpointInfoProfiles         = "Profiles"
+	EndpointInfoPool             = "Pool"
+)
+
 // Driver .
 type Driver struct {
 	client         clientv3.Interface
 	dockerCli      *dockerClient.Client
+	pools          *calDriver.PoolCache
 	containerName  string
 	orchestratorID string
 	namespace      string
 
-	ifPrefix string
+	ifPrefix     string
+	hostIFPrefix string
+	vethNames    VethNamer
 
 	DummyIPV4Nexthop string
+	// DummyIPV6Nexthop is added to host side veths of IPv6 endpoints, so their gateway is the same
+	// link local address everywhere and doesn't wait for the kernel to generate one
+	DummyIPV6Nexthop string
 
 	vethMTU uint16
 
 	labelPollTimeout time.Duration
 
 	createProfiles bool
+	deleteProfiles bool
 	labelEndpoints bool
 }
 
@@ -67,15 +84,21 @@ type Driver struct {
 func NewNetworkDriver(
 	client clientv3.Interface,
 	dockerCli *dockerClient.Client,
-) Driver {
+	pools *calDriver.PoolCache,
+) (Driver, error) {
 	hostname, err := osutils.GetHostname()
 	if err != nil {
-		log.Fatalf("Hostname fetching error, %v", err)
+		return Driver{}, errors.Wrap(err, "Hostname fetching error")
+	}
+	vethNames, err := NewVethNamer(HostIFPrefix)
+	if err != nil {
+		return Driver{}, errors.Wrap(err, "CALICO_LIBNETWORK_HOST_IFPREFIX error")
 	}
 
 	driver := Driver{
 		client:    client,
 		dockerCli: dockerCli,
+		pools:     pools,
 
 		// Orchestrator and container IDs used in our endpoint identification. These
 		// are fixed for libnetwork.  Unique endpoint identification is provided by
@@ -85,11 +108,17 @@ func NewNetworkDriver(
 		namespace:      hostname,
 
 		ifPrefix:         IFPrefix,
+		hostIFPrefix:     HostIFPrefix,
+		vethNames:        vethNames,
 		DummyIPV4Nexthop: "169.254.1.1",
+		DummyIPV6Nexthop: "fe80::1",
 
 		// default: enabled, disable by setting env key to false (case insensitive)
 		createProfiles: !strings.EqualFold(os.Getenv(CREATE_PROFILES_ENVKEY), "false"),
 
+		// default: disabled, enable by setting env key to true (case insensitive)
+		deleteProfiles: strings.EqualFold(os.Getenv(DELETE_PROFILES_ENVKEY), "true"),
+
 		// default: disabled, enable by setting env key to true (case insensitive)
 		labelEndpoints: strings.EqualFold(os.Getenv(LABEL_ENDPOINTS_ENVKEY), "true"),
 	}
@@ -104,8 +133,7 @@ func NewNetworkDriver(
 	if mtuStr, ok := os.LookupEnv(VETH_MTU_ENVKEY); ok {
 		mtu, err := strconv.ParseUint(mtuStr, 10, 16)
 		if err != nil {
-			log.Fatalf("Failed to parse %v '%v' into uint16: %v",
-				VETH_MTU_ENVKEY, mtuStr, err)
+			return Driver{}, errors.Wrapf(err, "Failed to parse %v '%v' into uint16", VETH_MTU_ENVKEY, mtuStr)
 		}
 
 		driver.vethMTU = uint16(mtu)
@@ -116,11 +144,14 @@ func NewNetworkDriver(
 	if !driver.createProfiles {
 		log.Info("Feature disabled: no Calico profiles will be created per network")
 	}
+	if driver.createProfiles && driver.deleteProfiles {
+		log.Info("Feature enabled: Calico profiles will be deleted along with their networks")
+	}
 	if driver.labelEndpoints {
 		log.Info("Feature enabled: Calico workloadendpoints will be labelled with Docker labels")
 		driver.labelPollTimeout = getLabelPollTimeout()
 	}
-	return driver
+	return driver, nil
 }
 
 // Returns the label poll timeout. Default is returned unless an environment
@@ -167,7 +198,7 @@ func (d Driver) FreeNetwork(request *network.FreeNetworkRequest) error {
 	return nil
 }
 
-func (d Driver) CreateNetwork(request *network.CreateNetworkRequest) error {
+func (d Driver) CreateNetwork(ctx context.Context, request *network.CreateNetworkRequest) error {
 	logutils.JSONMessage("CreateNetwork", request)
 	knownOpts := map[string]bool{"com.docker.network.enable_ipv6": true}
 	// Reject all options (--internal, --enable_ipv6, etc)
@@ -255,18 +286,35 @@ func (d Driver) CreateNetwork(request *network.CreateNetworkRequest) error {
 	}
 
 	logutils.JSONMessage("CreateNetwork response", map[string]string{})
-	return d.populatePoolLabel(ps, request.NetworkID)
+	return d.populatePoolLabel(ctx, ps, request.NetworkID)
 }
 
-func (d Driver) DeleteNetwork(request *network.DeleteNetworkRequest) error {
+// DeleteNetwork removes the network from its pools, and deletes the profiles created for the pools
+// no longer serving any network when deleteProfiles is enabled
+func (d Driver) DeleteNetwork(ctx context.Context, request *network.DeleteNetworkRequest) error {
 	logutils.JSONMessage("DeleteNetwork", request)
+	pools, err := d.removePoolLabel(ctx, request.NetworkID)
+	if err != nil {
+		return err
+	}
+	if d.createProfiles && d.deleteProfiles {
+		for _, name := range pools {
+			if _, err := d.client.Profiles().Delete(ctx, name, options.DeleteOptions{}); err != nil {
+				if _, ok := err.(libcalicoErrors.ErrorResourceDoesNotExist); !ok {
+					log.Errorf("[calico.NetworkDriver::DeleteNetwork] delete profile %s error, %v", name, err)
+					return err
+				}
+			}
+			log.Infof("[calico.NetworkDriver::DeleteNetwork] profile %s deleted", name)
+		}
+	}
+	logutils.JSONMessage("DeleteNetwork response", map[string]string{})
 	return nil
 }
 
-func (d Driver) CreateEndpoint(request *network.CreateEndpointRequest) (*network.CreateEndpointResponse, error) {
+func (d Driver) CreateEndpoint(ctx context.Context, request *network.CreateEndpointRequest) (*network.CreateEndpointResponse, error) {
 	logutils.JSONMessage("CreateEndpoint", request)
 
-	ctx := context.Background()
 	hostname, err := osutils.GetHostname()
 	if err != nil {
 		err = errors.Wrap(err, "Hostname fetching error")
@@ -297,13 +345,14 @@ func (d Driver) CreateEndpoint(request *network.CreateEndpointRequest) (*network
 
 	if request.Interface.AddressIPv6 != "" {
 		// Parse the address this function was passed.
-		ip6, ipnet, err := net.ParseCIDR(request.Interface.AddressIPv6)
+		// Parse the address this function was passed. Ignore the subnet - Calico always uses /128 (for IPv6)
+		ip6, _, err := net.ParseCIDR(request.Interface.AddressIPv6)
 		log.Debugf("Parsed IP %v from (%v) \n", ip6, request.Interface.AddressIPv6)
 		if err != nil {
 			log.Errorf("Parsing %v as CIDR failed, %v", request.Interface.AddressIPv6, err)
 			return nil, err
 		}
-		addresses = append(addresses, caliconet.IPNet{IPNet: *ipnet})
+		addresses = append(addresses, caliconet.IPNet{IPNet: net.IPNet{IP: ip6, Mask: net.CIDRMask(128, 128)}})
 	}
 
 	wepName, err := d.generateEndpointName(hostname, request.EndpointID)
@@ -320,7 +369,7 @@ func (d Driver) CreateEndpoint(request *network.CreateEndpointRequest) (*network
 	endpoint.Spec.Node = hostname
 	endpoint.Spec.Orchestrator = d.orchestratorID
 	endpoint.Spec.Workload = d.containerName
-	endpoint.Spec.InterfaceName = "cali" + request.EndpointID[:mathutils.MinInt(11, len(request.EndpointID))]
+	endpoint.Spec.InterfaceName, _ = d.vethNames(request.EndpointID)
 	var mac net.HardwareAddr
 	if request.Interface.MacAddress != "" {
 		if mac, err = net.ParseMAC(request.Interface.MacAddress); err != nil {
@@ -333,21 +382,16 @@ func (d Driver) CreateEndpoint(request *network.CreateEndpointRequest) (*network
 		endpoint.Spec.IPNetworks = append(endpoint.Spec.IPNetworks, addr.String())
 	}
 
-	pools, err := d.client.IPPools().List(ctx, options.ListOptions{})
+	pool, f, err := d.pools.Find(ctx, func(p *api.IPPool) bool { return PoolHasNetwork(p, request.NetworkID) })
 	if err != nil {
 		log.Errorf("Network %v gather error, %v", request.NetworkID, err)
 		return nil, err
 	}
 
-	f := false
 	networkName := ""
-	for _, p := range pools.Items {
-		if nid, ok := p.Annotations[DOCKER_LABEL_PREFIX+"network.ID"]; ok && nid == request.NetworkID {
-			f = true
-			networkName = p.ObjectMeta.Name
-			log.Debugf("Find ippool : %v\n", p.Name)
-			break
-		}
+	if f {
+		networkName = pool.ObjectMeta.Name
+		log.Debugf("Find ippool : %v\n", pool.Name)
 	}
 	if !f {
 		log.Errorln(types.ErrCIDRNotInPool)
@@ -375,6 +419,8 @@ func (d Driver) CreateEndpoint(request *network.CreateEndpointRequest) (*network
 						}}},
 				},
 			}
+			// The profile is shared by endpoints of the network, it's kept when this endpoint fails,
+			// since concurrent endpoints may already reference it. DeleteNetwork removes it.
 			if _, err := d.client.Profiles().Create(ctx, profile, options.SetOptions{}); err != nil {
 				if _, ok := err.(libcalicoErrors.ErrorResourceAlreadyExists); !ok {
 					log.Errorln(err)
@@ -402,7 +448,7 @@ func (d Driver) CreateEndpoint(request *network.CreateEndpointRequest) (*network
 	return response, nil
 }
 
-func (d Driver) DeleteEndpoint(request *network.DeleteEndpointRequest) error {
+func (d Driver) DeleteEndpoint(ctx context.Context, request *network.DeleteEndpointRequest) error {
 	logutils.JSONMessage("DeleteEndpoint", request)
 	log.Debugf("Removing endpoint %v\n", request.EndpointID)
 
@@ -420,7 +466,7 @@ func (d Driver) DeleteEndpoint(request *network.DeleteEndpointRequest) error {
 	}
 
 	if _, err = d.client.WorkloadEndpoints().Delete(
-		context.Background(), d.namespace,
+		ctx, d.namespace,
 		wepName, options.DeleteOptions{}); err != nil {
 		log.Errorf("Endpoint %v removal error, %v", request.EndpointID, err)
 		return err
@@ -431,22 +477,51 @@ func (d Driver) DeleteEndpoint(request *network.DeleteEndpointRequest) error {
 	return err
 }
 
-func (d Driver) EndpointInfo(request *network.InfoRequest) (*network.InfoResponse, error) {
+// EndpointInfo returns the workload endpoint of the docker endpoint, shown by docker network inspect.
+// Missing data is left out instead of failing the inspection.
+func (d Driver) EndpointInfo(ctx context.Context, request *network.InfoRequest) (*network.InfoResponse, error) {
 	logutils.JSONMessage("EndpointInfo", request)
-	return nil, nil
+	resp := &network.InfoResponse{Value: map[string]string{}}
+
+	if pool, err := d.FindPoolByNetworkID(ctx, request.NetworkID); err == nil {
+		resp.Value[EndpointInfoPool] = pool.Name
+	}
+
+	hostname, err := osutils.GetHostname()
+	if err != nil {
+		log.Errorf("[calico.NetworkDriver::EndpointInfo] Hostname fetching error, %v", err)
+		return resp, nil
+	}
+	wepName, err := d.generateEndpointName(hostname, request.EndpointID)
+	if err != nil {
+		log.Errorf("[calico.NetworkDriver::EndpointInfo] generate endpoint name error, %v", err)
+		return resp, nil
+	}
+	wep, err := d.client.WorkloadEndpoints().Get(ctx, d.namespace, wepName, options.GetOptions{})
+	if err != nil {
+		log.Errorf("[calico.NetworkDriver::EndpointInfo] get workload endpoint %s error, %v", wepName, err)
+		return resp, nil
+	}
+	resp.Value[EndpointInfoWorkloadEndpoint] = wep.Name
+	resp.Value[EndpointInfoInterfaceName] = wep.Spec.InterfaceName
+	resp.Value[EndpointInfoMAC] = wep.Spec.MAC
+	resp.Value[EndpointInfoIPNetworks] = strings.Join(wep.Spec.IPNetworks, ",")
+	resp.Value[EndpointInfoProfiles] = strings.Join(wep.Spec.Profiles, ",")
+
+	logutils.JSONMessage("EndpointInfo response", resp)
+	return resp, nil
 }
 
-func (d Driver) Join(request *network.JoinRequest) (*network.JoinResponse, error) {
+func (d Driver) Join(ctx context.Context, request *network.JoinRequest) (_ *network.JoinResponse, err error) {
 	logutils.JSONMessage("Join", request)
 
-	ctx := context.Background()
+	undo := &undoStack{}
+	defer undo.runIfFailed(&err)
+
 	// 1) Set up a veth pair
-	// 	The one end will stay in the host network namespace - named caliXXXXX
+	// 	The one end will stay in the host network namespace - named by the interface prefix, caliXXXXX by default
 	//	The other end is given a temporary name. It's moved into the final network namespace by libnetwork itself.
-	var err error
-	prefix := request.EndpointID[:mathutils.MinInt(11, len(request.EndpointID))]
-	hostInterfaceName := "cali" + prefix
-	tempInterfaceName := "temp" + prefix
+	hostInterfaceName, tempInterfaceName := d.vethNames(request.EndpointID)
 
 	if err = netns.CreateVeth(hostInterfaceName, tempInterfaceName, d.vethMTU); err != nil {
 		log.Errorf(
@@ -455,6 +530,13 @@ func (d Driver) Join(request *network.JoinRequest) (*network.JoinResponse, error
 		)
 		return nil, err
 	}
+	undo.push("veth "+hostInterfaceName, func(context.Context) error {
+		return netns.RemoveVeth(hostInterfaceName)
+	})
+	if err = markHostVeth(hostInterfaceName); err != nil {
+		// the veth is still found by its workload endpoint when sweeping
+		log.Warnf("Marking veth %s error, %v", hostInterfaceName, err)
+	}
 
 	// 2) update workloads
 	hostname, err := os.Hostname()
@@ -478,17 +560,22 @@ func (d Driver) Join(request *network.JoinRequest) (*network.JoinResponse, error
 		log.Errorln(err)
 		return nil, err
 	}
+	previousMAC := wep.Spec.MAC
 	wep.Spec.MAC = tempNIC.Attrs().HardwareAddr.String()
-	_, err = weps.Update(ctx, wep, options.SetOptions{})
-	if err != nil {
+	if wep, err = weps.Update(ctx, wep, options.SetOptions{}); err != nil {
 		log.Errorln(err)
 		return nil, err
 	}
+	undo.push("mac of workload endpoint "+wepName, func(ctx context.Context) error {
+		wep.Spec.MAC = previousMAC
+		_, err := weps.Update(ctx, wep, options.SetOptions{})
+		return err
+	})
 
 	resp := &network.JoinResponse{
 		InterfaceName: network.InterfaceName{
 			SrcName:   tempInterfaceName,
-			DstPrefix: IFPrefix,
+			DstPrefix: d.ifPrefix,
 		},
 	}
 
@@ -497,22 +584,24 @@ func (d Driver) Join(request *network.JoinRequest) (*network.JoinResponse, error
 	// configured on the endpoint (which will be our host IPs).
 	log.Debugln("Using Calico IPAM driver, configure gateway and static routes to the host")
 
-	resp.Gateway = d.DummyIPV4Nexthop
-	resp.StaticRoutes = append(resp.StaticRoutes, &network.StaticRoute{
-		Destination: d.DummyIPV4Nexthop + "/32",
-		RouteType:   1, // 1 = CONNECTED
-		NextHop:     "",
-	})
+	hasIPv4, hasIPv6 := ipVersions(wep.Spec.IPNetworks)
+	if hasIPv4 || !hasIPv6 {
+		resp.Gateway = d.DummyIPV4Nexthop
+		resp.StaticRoutes = append(resp.StaticRoutes, &network.StaticRoute{
+			Destination: d.DummyIPV4Nexthop + "/32",
+			RouteType:   1, // 1 = CONNECTED
+			NextHop:     "",
+		})
+	}
 
-	linkLocalAddr := netns.GetLinkLocalAddr(hostInterfaceName)
-	if linkLocalAddr == nil {
-		log.Warnf("No IPv6 link local address for %s", hostInterfaceName)
-	} else {
-		//nolint:gosimple
-		resp.GatewayIPv6 = fmt.Sprintf("%s", linkLocalAddr)
-		nextHopIPv6 := fmt.Sprintf("%s/128", linkLocalAddr)
+	if hasIPv6 {
+		if err = addLinkLocalAddr(hostInterfaceName, d.DummyIPV6Nexthop); err != nil {
+			log.Errorf("Adding IPv6 nexthop %s to %s error, %v", d.DummyIPV6Nexthop, hostInterfaceName, err)
+			return nil, err
+		}
+		resp.GatewayIPv6 = d.DummyIPV6Nexthop
 		resp.StaticRoutes = append(resp.StaticRoutes, &network.StaticRoute{
-			Destination: nextHopIPv6,
+			Destination: d.DummyIPV6Nexthop + "/128",
 			RouteType:   1, // 1 = CONNECTED
 			NextHop:     "",
 		})
@@ -525,29 +614,124 @@ func (d Driver) Join(request *network.JoinRequest) (*network.JoinResponse, error
 
 // Leave .
 func (d Driver) Leave(request *network.LeaveRequest) error {
-	caliName := "cali" + request.EndpointID[:mathutils.MinInt(11, len(request.EndpointID))]
-	return netns.RemoveVeth(caliName)
+	hostInterfaceName, _ := d.vethNames(request.EndpointID)
+	return netns.RemoveVeth(hostInterfaceName)
 }
 
 // FindPoolByNetworkID .
-func (d Driver) FindPoolByNetworkID(networkID string) (*api.IPPool, error) {
-	var (
-		pools *api.IPPoolList
-		err   error
-	)
-
-	if pools, err = d.client.IPPools().List(context.Background(), options.ListOptions{}); err != nil {
+func (d Driver) FindPoolByNetworkID(ctx context.Context, networkID string) (*api.IPPool, error) {
+	pool, found, err := d.pools.Find(ctx, func(p *api.IPPool) bool { return PoolHasNetwork(p, networkID) })
+	if err != nil {
 		log.Errorf("[calico.NetworkDriver::FindPoolByNetworkID] Network %v gather error, %v", networkID, err)
 		return nil, err
 	}
+	if found {
+		return pool, nil
+	}
+
+	return nil, errors.Errorf("[calico.NetworkDriver::findPoolByNetworkID] Not find pool by networkID, %s", networkID)
+}
+
+// FindPoolByAddress returns the pool of the network which contains ip,
+// a dual stack network is served by an IPv4 pool and an IPv6 pool
+func (d Driver) FindPoolByAddress(ctx context.Context, networkID string, ip net.IP) (*api.IPPool, error) {
+	pool, found, err := d.pools.Find(ctx, func(p *api.IPPool) bool {
+		if !PoolHasNetwork(p, networkID) {
+			return false
+		}
+		_, cidr, err := net.ParseCIDR(p.Spec.CIDR)
+		return err == nil && cidr.Contains(ip)
+	})
+	if err != nil {
+		log.Errorf("[calico.NetworkDriver::FindPoolByAddress] Network %v gather error, %v", networkID, err)
+		return nil, err
+	}
+	if !found {
+		return nil, errors.Errorf("[calico.NetworkDriver::FindPoolByAddress] Not find pool of ip %s by networkID, %s", ip, networkID)
+	}
+	return pool, nil
+}
 
-	for _, p := range pools.Items {
-		if nid, ok := p.Annotations[DOCKER_LABEL_PREFIX+"network.ID"]; ok && nid == networkID {
-			return &p, nil
+// ListEndpoints lists workload endpoints created by this driver on this host
+func (d Driver) ListEndpoints(ctx context.Context) ([]api.WorkloadEndpoint, error) {
+	hostname, err := osutils.GetHostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "Hostname fetching error")
+	}
+	weps, err := d.client.WorkloadEndpoints().List(ctx, options.ListOptions{Namespace: d.namespace})
+	if err != nil {
+		log.Errorf("[calico.NetworkDriver::ListEndpoints] list workload endpoints error, %v", err)
+		return nil, err
+	}
+	var endpoints []api.WorkloadEndpoint
+	for _, wep := range weps.Items {
+		if wep.Spec.Node == hostname && wep.Spec.Orchestrator == d.orchestratorID {
+			endpoints = append(endpoints, wep)
 		}
 	}
+	return endpoints, nil
+}
 
-	return nil, errors.Errorf("[calico.NetworkDriver::findPoolByNetworkID] Not find pool by networkID, %s", networkID)
+// HostVethName returns the name of the host side veth of the endpoint
+func (d Driver) HostVethName(endpointID string) string {
+	name, _ := d.vethNames(endpointID)
+	return name
+}
+
+// ListHostVeths lists host side veths created by this driver, weps are listed by ListEndpoints.
+// Veths without the alias are recognized by interface names of weps, or by the host interface prefix
+// when no wep of other orchestrators on this host, e.g. calico CNI, names them
+func (d Driver) ListHostVeths(ctx context.Context, weps []api.WorkloadEndpoint) ([]string, error) {
+	known := make(map[string]bool, len(weps))
+	for _, wep := range weps {
+		known[wep.Spec.InterfaceName] = true
+	}
+	foreign, err := d.foreignInterfaceNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names, err := listHostVeths(d.hostIFPrefix, known, foreign)
+	if err != nil {
+		log.Errorf("[calico.NetworkDriver::ListHostVeths] list links error, %v", err)
+	}
+	return names, err
+}
+
+// foreignInterfaceNames returns interface names of weps on this host of other orchestrators
+func (d Driver) foreignInterfaceNames(ctx context.Context) (map[string]bool, error) {
+	hostname, err := osutils.GetHostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "Hostname fetching error")
+	}
+	// weps of all namespaces are listed
+	weps, err := d.client.WorkloadEndpoints().List(ctx, options.ListOptions{})
+	if err != nil {
+		log.Errorf("[calico.NetworkDriver::foreignInterfaceNames] list workload endpoints error, %v", err)
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, wep := range weps.Items {
+		if wep.Spec.Node == hostname && wep.Spec.Orchestrator != d.orchestratorID {
+			names[wep.Spec.InterfaceName] = true
+		}
+	}
+	return names, nil
+}
+
+// RemoveHostVeth removes the host side veth, and its peer with it
+func (d Driver) RemoveHostVeth(name string) error {
+	return netns.RemoveVeth(name)
+}
+
+// RemoveEndpoint deletes the workload endpoint listed by ListEndpoints
+func (d Driver) RemoveEndpoint(ctx context.Context, wep *api.WorkloadEndpoint) error {
+	if _, err := d.client.WorkloadEndpoints().Delete(ctx, wep.Namespace, wep.Name, options.DeleteOptions{}); err != nil {
+		if _, ok := err.(libcalicoErrors.ErrorResourceDoesNotExist); !ok {
+			log.Errorf("[calico.NetworkDriver::RemoveEndpoint] delete workload endpoint %s error, %v", wep.Name, err)
+			return err
+		}
+	}
+	return nil
 }
 
 func (d Driver) DiscoverNew(request *network.DiscoveryNotification) error {
@@ -584,7 +768,9 @@ func (d Driver) RevokeExternalConnectivity(*network.RevokeExternalConnectivityRe
 // container list in the NetworkInspect and make the Container available
 // for inspecting.
 func (d Driver) populateWorkloadEndpointWithLabels(request *network.CreateEndpointRequest, endpoint *api.WorkloadEndpoint) {
-	ctx := context.Background()
+	// it runs after CreateEndpoint returns, so it's bounded by the poll timeout instead of the request
+	ctx, cancel := context.WithTimeout(context.Background(), d.labelPollTimeout)
+	defer cancel()
 
 	networkID := request.NetworkID
 	endpointID := request.EndpointID
@@ -725,28 +911,52 @@ func (d Driver) generateEndpointName(hostname, endpointID string) (string, error
 	return wepNameIdent.CalculateWorkloadEndpointName(false)
 }
 
-func (d Driver) populatePoolLabel(pools []string, networkID string) error {
-	ctx := context.Background()
-	poolClient := d.client.IPPools()
-	ipPools, err := poolClient.List(ctx, options.ListOptions{})
+// removePoolLabel removes the network from its pools, returns names of the pools serving no network then
+func (d Driver) removePoolLabel(ctx context.Context, networkID string) ([]string, error) {
+	ipPools, err := d.pools.List(ctx)
+	if err != nil {
+		log.Errorln(err)
+		return nil, err
+	}
+	defer d.pools.Invalidate()
+	var unused []string
+	for i := range ipPools {
+		ipPool := &ipPools[i]
+		if !PoolHasNetwork(ipPool, networkID) {
+			continue
+		}
+		if ipPool, err = d.updatePool(ctx, ipPool, func(pool *api.IPPool) bool {
+			return removePoolNetwork(pool, networkID)
+		}); err != nil {
+			log.Errorln(err)
+			return nil, err
+		}
+		if len(PoolNetworkIDs(ipPool)) == 0 {
+			unused = append(unused, ipPool.Name)
+		}
+	}
+	return unused, nil
+}
+
+// populatePoolLabel adds the network to pools of the CIDRs, a pool can serve multiple networks
+func (d Driver) populatePoolLabel(ctx context.Context, pools []string, networkID string) error {
+	ipPools, err := d.pools.List(ctx)
 	if err != nil {
 		log.Errorln(err)
 		return err
 	}
-	for _, ipPool := range ipPools.Items {
+	defer d.pools.Invalidate()
+	for i := range ipPools {
+		ipPool := &ipPools[i]
 		for _, cidr := range pools {
-			if ipPool.Spec.CIDR == cidr {
-				ann := ipPool.GetAnnotations()
-				if ann == nil {
-					ann = map[string]string{}
-				}
-				ann[DOCKER_LABEL_PREFIX+"network.ID"] = networkID
-				ipPool.SetAnnotations(ann)
-				// TODO need remove nolint and use unittest to cover this case
-				if _, err = poolClient.Update(ctx, &ipPool, options.SetOptions{}); err != nil { // nolint
-					log.Errorln(err)
-					return err
-				}
+			if ipPool.Spec.CIDR != cidr {
+				continue
+			}
+			if _, err = d.updatePool(ctx, ipPool, func(pool *api.IPPool) bool {
+				return addPoolNetwork(pool, networkID)
+			}); err != nil {
+				log.Errorln(err)
+				return err
 			}
 		}
 	}
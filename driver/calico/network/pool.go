@@ -0,0 +1,108 @@
+package network
+
+import (
+	"context"
+	"strings"
+
+	api "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	libcalicoErrors "github.com/projectcalico/libcalico-go/lib/errors"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// NetworkIDAnnotation holds IDs of docker networks served by the pool, separated by commas,
+	// a single ID written by older versions is a set of one
+	NetworkIDAnnotation = DOCKER_LABEL_PREFIX + "network.ID"
+
+	// maxPoolUpdateRetries bounds retries of pool updates conflicting with other nodes
+	maxPoolUpdateRetries = 5
+)
+
+// PoolNetworkIDs returns IDs of docker networks served by the pool
+func PoolNetworkIDs(pool *api.IPPool) []string {
+	value := pool.Annotations[NetworkIDAnnotation]
+	if value == "" {
+		return nil
+	}
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// PoolHasNetwork returns whether the pool serves the docker network
+func PoolHasNetwork(pool *api.IPPool, networkID string) bool {
+	for _, id := range PoolNetworkIDs(pool) {
+		if id == networkID {
+			return true
+		}
+	}
+	return false
+}
+
+// addPoolNetwork adds networkID to the pool, returns false when it's already there
+func addPoolNetwork(pool *api.IPPool, networkID string) bool {
+	if PoolHasNetwork(pool, networkID) {
+		return false
+	}
+	setPoolNetworkIDs(pool, append(PoolNetworkIDs(pool), networkID))
+	return true
+}
+
+// removePoolNetwork removes networkID from the pool, returns false when it's not there
+func removePoolNetwork(pool *api.IPPool, networkID string) bool {
+	ids := PoolNetworkIDs(pool)
+	left := ids[:0]
+	for _, id := range ids {
+		if id != networkID {
+			left = append(left, id)
+		}
+	}
+	if len(left) == len(ids) {
+		return false
+	}
+	setPoolNetworkIDs(pool, left)
+	return true
+}
+
+func setPoolNetworkIDs(pool *api.IPPool, ids []string) {
+	ann := pool.GetAnnotations()
+	if ann == nil {
+		ann = map[string]string{}
+	}
+	if len(ids) == 0 {
+		delete(ann, NetworkIDAnnotation)
+	} else {
+		ann[NetworkIDAnnotation] = strings.Join(ids, ",")
+	}
+	pool.SetAnnotations(ann)
+}
+
+// updatePool applies mutate on the pool and saves it, the pool is reloaded and mutated again on conflicts.
+// mutate returns false when nothing changes.
+func (d Driver) updatePool(ctx context.Context, pool *api.IPPool, mutate func(*api.IPPool) bool) (*api.IPPool, error) {
+	var err error
+	for i := 0; i < maxPoolUpdateRetries; i++ {
+		if i > 0 {
+			if pool, err = d.client.IPPools().Get(ctx, pool.Name, options.GetOptions{}); err != nil {
+				return nil, err
+			}
+		}
+		if !mutate(pool) {
+			return pool, nil
+		}
+		var updated *api.IPPool
+		if updated, err = d.client.IPPools().Update(ctx, pool, options.SetOptions{}); err == nil {
+			return updated, nil
+		}
+		if _, ok := err.(libcalicoErrors.ErrorResourceUpdateConflict); !ok {
+			return nil, err
+		}
+		log.Warnf("[calico.NetworkDriver::updatePool] pool %s is updated by others, retrying", pool.Name)
+	}
+	return nil, err
+}
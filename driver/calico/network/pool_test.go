@@ -0,0 +1,34 @@
+package network
+
+import (
+	"testing"
+
+	api "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolNetworks(t *testing.T) {
+	pool := api.NewIPPool()
+	assert.Empty(t, PoolNetworkIDs(pool))
+	assert.False(t, PoolHasNetwork(pool, "n1"))
+
+	// written by older versions
+	pool.SetAnnotations(map[string]string{NetworkIDAnnotation: "n1"})
+	assert.Equal(t, []string{"n1"}, PoolNetworkIDs(pool))
+	assert.True(t, PoolHasNetwork(pool, "n1"))
+
+	assert.True(t, addPoolNetwork(pool, "n2"))
+	assert.False(t, addPoolNetwork(pool, "n2"))
+	assert.Equal(t, "n1,n2", pool.Annotations[NetworkIDAnnotation])
+	assert.True(t, PoolHasNetwork(pool, "n1"))
+	assert.True(t, PoolHasNetwork(pool, "n2"))
+	assert.False(t, PoolHasNetwork(pool, "n"))
+
+	assert.True(t, removePoolNetwork(pool, "n1"))
+	assert.False(t, removePoolNetwork(pool, "n1"))
+	assert.Equal(t, []string{"n2"}, PoolNetworkIDs(pool))
+
+	assert.True(t, removePoolNetwork(pool, "n2"))
+	_, ok := pool.Annotations[NetworkIDAnnotation]
+	assert.False(t, ok)
+}
@@ -0,0 +1,47 @@
+package network
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// undoTimeout bounds all undo actions of a failed call, they can't use the request context,
+// which may be the very reason of the failure
+const undoTimeout = 10 * time.Second
+
+type undoAction struct {
+	name string
+	undo func(context.Context) error
+}
+
+// undoStack collects actions undoing side effects of the steps done so far,
+// when a later step fails they run in reverse order, so the failed call leaves nothing behind
+type undoStack struct {
+	actions []undoAction
+}
+
+// push registers the undo action of a step just done
+func (s *undoStack) push(name string, undo func(context.Context) error) {
+	s.actions = append(s.actions, undoAction{name: name, undo: undo})
+}
+
+// runIfFailed runs the actions when *err is not nil, it's meant to be deferred with the named error result.
+// Failed actions are logged and the rest still run.
+func (s *undoStack) runIfFailed(err *error) {
+	if *err == nil || len(s.actions) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), undoTimeout)
+	defer cancel()
+	for i := len(s.actions) - 1; i >= 0; i-- {
+		action := s.actions[i]
+		if undoErr := action.undo(ctx); undoErr != nil {
+			log.Errorf("[calico.NetworkDriver::undo] undo %s error, %v", action.name, undoErr)
+			continue
+		}
+		log.Infof("[calico.NetworkDriver::undo] %s undone after failure, %v", action.name, *err)
+	}
+	s.actions = nil
+}
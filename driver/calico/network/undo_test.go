@@ -0,0 +1,46 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndoStack(t *testing.T) {
+	var undone []string
+	newStack := func() *undoStack {
+		stack := &undoStack{}
+		stack.push("veth", func(ctx context.Context) error {
+			assert.NoError(t, ctx.Err())
+			undone = append(undone, "veth")
+			return nil
+		})
+		stack.push("profile", func(ctx context.Context) error {
+			assert.NoError(t, ctx.Err())
+			undone = append(undone, "profile")
+			return errors.New("datastore down")
+		})
+		stack.push("mac", func(ctx context.Context) error {
+			assert.NoError(t, ctx.Err())
+			undone = append(undone, "mac")
+			return nil
+		})
+		return stack
+	}
+
+	var err error
+	newStack().runIfFailed(&err)
+	assert.Empty(t, undone)
+
+	err = errors.New("update wep failed")
+	stack := newStack()
+	stack.runIfFailed(&err)
+	// reverse order, and failed actions don't stop the rest
+	assert.Equal(t, []string{"mac", "profile", "veth"}, undone)
+
+	// actions run once
+	stack.runIfFailed(&err)
+	assert.Len(t, undone, 3)
+}
@@ -0,0 +1,187 @@
+package calico
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	log "github.com/sirupsen/logrus"
+)
+
+// PoolCache caches calico pools for lookups by name, CIDR or any condition.
+// Pools are reloaded once the cache is older than resync, and on a lookup miss,
+// so pools and mappings written by other nodes are found at once. resync 0 disables caching.
+// Lookups arriving while pools are being loaded wait for that load instead of listing again,
+// the lock is never held across the datastore.
+// Returned pools are copies, callers may change them.
+type PoolCache struct {
+	client clientv3.Interface
+	resync time.Duration
+
+	mu       sync.Mutex
+	snapshot *poolSnapshot
+	loading  *poolLoad
+	// generation is bumped by Invalidate, so loads started before are not cached
+	generation uint64
+}
+
+type poolSnapshot struct {
+	loadedAt time.Time
+	pools    []apiv3.IPPool
+	byName   map[string]int
+	byCIDR   map[string]int
+}
+
+// poolLoad is a load in flight, done is closed once snapshot or err is set
+type poolLoad struct {
+	done       chan struct{}
+	generation uint64
+	snapshot   *poolSnapshot
+	err        error
+}
+
+// NewPoolCache .
+func NewPoolCache(client clientv3.Interface, resync time.Duration) *PoolCache {
+	return &PoolCache{client: client, resync: resync}
+}
+
+// List returns all pools
+func (c *PoolCache) List(ctx context.Context) ([]apiv3.IPPool, error) {
+	snapshot, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pools := make([]apiv3.IPPool, 0, len(snapshot.pools))
+	for i := range snapshot.pools {
+		pools = append(pools, *snapshot.pools[i].DeepCopy())
+	}
+	return pools, nil
+}
+
+// Get returns the pool by name, returns false when not found
+func (c *PoolCache) Get(ctx context.Context, name string) (*apiv3.IPPool, bool, error) {
+	return c.lookup(ctx, func(snapshot *poolSnapshot) (int, bool) {
+		i, ok := snapshot.byName[name]
+		return i, ok
+	})
+}
+
+// GetByCIDR returns the pool by its normalized CIDR, returns false when not found
+func (c *PoolCache) GetByCIDR(ctx context.Context, cidr string) (*apiv3.IPPool, bool, error) {
+	return c.lookup(ctx, func(snapshot *poolSnapshot) (int, bool) {
+		i, ok := snapshot.byCIDR[cidr]
+		return i, ok
+	})
+}
+
+// Find returns the first pool matched, returns false when not found
+func (c *PoolCache) Find(ctx context.Context, match func(*apiv3.IPPool) bool) (*apiv3.IPPool, bool, error) {
+	return c.lookup(ctx, func(snapshot *poolSnapshot) (int, bool) {
+		for i := range snapshot.pools {
+			if match(&snapshot.pools[i]) {
+				return i, true
+			}
+		}
+		return 0, false
+	})
+}
+
+// Invalidate makes the next lookup reload pools, call it after changing pools
+func (c *PoolCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snapshot = nil
+	c.loading = nil
+	c.generation++
+}
+
+func (c *PoolCache) lookup(ctx context.Context, find func(*poolSnapshot) (int, bool)) (*apiv3.IPPool, bool, error) {
+	snapshot, err := c.current(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	i, ok := find(snapshot)
+	if !ok {
+		// the pool may be created after the snapshot, so a miss waits for a reload
+		if snapshot, err = c.reload(ctx, snapshot); err != nil {
+			return nil, false, err
+		}
+		i, ok = find(snapshot)
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	return snapshot.pools[i].DeepCopy(), true, nil
+}
+
+// current returns the cached pools, they are reloaded when stale
+func (c *PoolCache) current(ctx context.Context) (*poolSnapshot, error) {
+	c.mu.Lock()
+	snapshot := c.snapshot
+	c.mu.Unlock()
+	if snapshot != nil && c.resync > 0 && time.Since(snapshot.loadedAt) < c.resync {
+		return snapshot, nil
+	}
+	return c.reload(ctx, snapshot)
+}
+
+// reload returns pools loaded after stale, it joins the load in flight or starts one
+func (c *PoolCache) reload(ctx context.Context, stale *poolSnapshot) (*poolSnapshot, error) {
+	c.mu.Lock()
+	if c.snapshot != nil && c.snapshot != stale {
+		// reloaded by others meanwhile
+		snapshot := c.snapshot
+		c.mu.Unlock()
+		return snapshot, nil
+	}
+	load := c.loading
+	if load == nil {
+		load = &poolLoad{done: make(chan struct{}), generation: c.generation}
+		c.loading = load
+		c.mu.Unlock()
+		c.load(ctx, load)
+	} else {
+		c.mu.Unlock()
+	}
+
+	select {
+	case <-load.done:
+		return load.snapshot, load.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load lists pools for load, the result is cached unless Invalidate is called meanwhile
+func (c *PoolCache) load(ctx context.Context, load *poolLoad) {
+	defer close(load.done)
+	pools, err := c.client.IPPools().List(ctx, options.ListOptions{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loading == load {
+		c.loading = nil
+	}
+	if err != nil {
+		log.Errorf("[PoolCache::load] list calico pools error, %v", err)
+		load.err = err
+		return
+	}
+	snapshot := &poolSnapshot{
+		loadedAt: time.Now(),
+		pools:    pools.Items,
+		byName:   make(map[string]int, len(pools.Items)),
+		byCIDR:   make(map[string]int, len(pools.Items)),
+	}
+	for i, pool := range snapshot.pools {
+		snapshot.byName[pool.Name] = i
+		snapshot.byCIDR[pool.Spec.CIDR] = i
+	}
+	load.snapshot = snapshot
+	if c.generation == load.generation {
+		c.snapshot = snapshot
+	}
+}
@@ -0,0 +1,124 @@
+package calico
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/libcalico-go/lib/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePools lists names as pools, each List blocks until release is closed when it's set
+type fakePools struct {
+	clientv3.Interface
+	clientv3.IPPoolInterface
+
+	mu      sync.Mutex
+	names   []string
+	release chan struct{}
+	lists   int32
+}
+
+func (f *fakePools) IPPools() clientv3.IPPoolInterface {
+	return f
+}
+
+func (f *fakePools) List(ctx context.Context, opts options.ListOptions) (*apiv3.IPPoolList, error) {
+	atomic.AddInt32(&f.lists, 1)
+	f.mu.Lock()
+	release := f.release
+	names := append([]string(nil), f.names...)
+	f.mu.Unlock()
+	if release != nil {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	list := apiv3.NewIPPoolList()
+	for _, name := range names {
+		pool := apiv3.NewIPPool()
+		pool.Name = name
+		list.Items = append(list.Items, *pool)
+	}
+	return list, nil
+}
+
+func (f *fakePools) add(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.names = append(f.names, name)
+}
+
+func TestPoolCacheFindsPoolsCreatedAfterLoad(t *testing.T) {
+	ctx := context.Background()
+	client := &fakePools{names: []string{"p1"}}
+	cache := NewPoolCache(client, time.Hour)
+
+	_, found, err := cache.Get(ctx, "p1")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	// created by another node right after the load
+	client.add("p2")
+	pool, found, err := cache.Get(ctx, "p2")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "p2", pool.Name)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&client.lists))
+
+	_, found, err = cache.Get(ctx, "p3")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestPoolCacheSharesLoads(t *testing.T) {
+	ctx := context.Background()
+	client := &fakePools{names: []string{"p1"}, release: make(chan struct{})}
+	cache := NewPoolCache(client, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, found, err := cache.Get(ctx, "p1")
+			assert.NoError(t, err)
+			assert.True(t, found)
+		}()
+	}
+	// the cache isn't locked while listing
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&client.lists) == 1
+	}, time.Second, time.Millisecond)
+	cache.mu.Lock()
+	cache.mu.Unlock() // nolint:staticcheck
+
+	close(client.release)
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&client.lists))
+
+	// waiters give up with their own context
+	client.mu.Lock()
+	client.release = make(chan struct{})
+	client.mu.Unlock()
+	cache.Invalidate()
+	go func() {
+		_, _, _ = cache.Get(ctx, "p1")
+	}()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&client.lists) == 2
+	}, time.Second, time.Millisecond)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, _, err := cache.Get(timeoutCtx, "p1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	close(client.release)
+}
@@ -0,0 +1,185 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	dockerNetworkTypes "github.com/docker/docker/api/types/network"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchRetryInterval is how long to wait before resubscribing docker events
+const watchRetryInterval = 5 * time.Second
+
+type indexedEndpoint struct {
+	container dockerTypes.Container
+	settings  *dockerNetworkTypes.EndpointSettings
+}
+
+// EndpointIndex maps endpoint IDs to their containers.
+// It's seeded by listing containers and kept up to date by docker events,
+// entries are kept after the container stopped until the endpoint leaves or the container is destroyed
+type EndpointIndex struct {
+	dockerCli *dockerClient.Client
+
+	mu        sync.RWMutex
+	endpoints map[string]indexedEndpoint
+}
+
+// NewEndpointIndex .
+func NewEndpointIndex(dockerCli *dockerClient.Client) *EndpointIndex {
+	return &EndpointIndex{
+		dockerCli: dockerCli,
+		endpoints: make(map[string]indexedEndpoint),
+	}
+}
+
+// Get returns the container and settings of the endpoint
+func (index *EndpointIndex) Get(endpointID string) (dockerTypes.Container, *dockerNetworkTypes.EndpointSettings, bool) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	endpoint, ok := index.endpoints[endpointID]
+	return endpoint.container, endpoint.settings, ok
+}
+
+// Add indexes endpoints of all networks of container
+func (index *EndpointIndex) Add(container dockerTypes.Container) {
+	if container.NetworkSettings == nil {
+		return
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	for _, settings := range container.NetworkSettings.Networks {
+		if settings == nil || settings.EndpointID == "" {
+			continue
+		}
+		index.endpoints[settings.EndpointID] = indexedEndpoint{container: container, settings: settings}
+	}
+}
+
+// Forget removes the endpoint
+func (index *EndpointIndex) Forget(endpointID string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	delete(index.endpoints, endpointID)
+}
+
+// ForgetContainer removes all endpoints of the container
+func (index *EndpointIndex) ForgetContainer(containerID string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	for endpointID, endpoint := range index.endpoints {
+		if endpoint.container.ID == containerID {
+			delete(index.endpoints, endpointID)
+		}
+	}
+}
+
+// Run seeds the index and follows docker events until ctx is done,
+// the index is reseeded whenever the event stream is resubscribed, so no event is missed
+func (index *EndpointIndex) Run(ctx context.Context) {
+	for {
+		index.watch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+func (index *EndpointIndex) watch(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	args.Add("type", events.NetworkEventType)
+	// subscribe before seeding, so changes during seeding are not lost
+	messages, errs := index.dockerCli.Events(ctx, dockerTypes.EventsOptions{Filters: args})
+	if err := index.seed(ctx); err != nil {
+		log.Errorf("[EndpointIndex::watch] seed endpoint index error, %v", err)
+		return
+	}
+	for {
+		select {
+		case message := <-messages:
+			index.handle(ctx, message)
+		case err := <-errs:
+			if ctx.Err() == nil {
+				log.Errorf("[EndpointIndex::watch] docker events error, resubscribing, %v", err)
+			}
+			return
+		}
+	}
+}
+
+func (index *EndpointIndex) seed(ctx context.Context) error {
+	containers, err := index.dockerCli.ContainerList(ctx, dockerTypes.ContainerListOptions{All: true})
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		index.Add(container)
+	}
+	log.Infof("[EndpointIndex::seed] %d containers indexed", len(containers))
+	return nil
+}
+
+func (index *EndpointIndex) handle(ctx context.Context, message events.Message) {
+	switch {
+	case message.Type == events.NetworkEventType && message.Action == "connect":
+		// the actor of network events is the network, the container is in attributes
+		index.inspect(ctx, message.Actor.Attributes["container"])
+	case message.Type == events.ContainerEventType && message.Action == "start":
+		index.inspect(ctx, message.Actor.ID)
+	case message.Type == events.ContainerEventType && message.Action == "destroy":
+		index.ForgetContainer(message.Actor.ID)
+	}
+}
+
+func (index *EndpointIndex) inspect(ctx context.Context, containerID string) {
+	if containerID == "" {
+		return
+	}
+	info, err := index.dockerCli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		log.Warnf("[EndpointIndex::inspect] inspect container %s error, %v", containerID, err)
+		return
+	}
+	container, err := containerFromInspect(info)
+	if err != nil {
+		log.Warnf("[EndpointIndex::inspect] %v", err)
+		return
+	}
+	index.Add(container)
+}
+
+// containerFromInspect converts inspect result to the list form, which drivers work with.
+// The base is missing from results of containers being removed.
+func containerFromInspect(info dockerTypes.ContainerJSON) (dockerTypes.Container, error) {
+	if info.ContainerJSONBase == nil {
+		return dockerTypes.Container{}, errors.New("inspect result has no container base, the container may be being removed")
+	}
+	container := dockerTypes.Container{
+		ID:    info.ID,
+		Names: []string{info.Name},
+	}
+	if info.Config != nil {
+		container.Labels = info.Config.Labels
+		container.Image = info.Config.Image
+	}
+	if info.State != nil {
+		container.State = info.State.Status
+	}
+	if info.NetworkSettings != nil {
+		container.NetworkSettings = &dockerTypes.SummaryNetworkSettings{Networks: info.NetworkSettings.Networks}
+	}
+	return container, nil
+}
@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	dockerNetworkTypes "github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newIndexedContainer(id string, endpointIDs ...string) dockerTypes.Container {
+	networks := make(map[string]*dockerNetworkTypes.EndpointSettings)
+	for _, endpointID := range endpointIDs {
+		networks["net-"+endpointID] = &dockerNetworkTypes.EndpointSettings{EndpointID: endpointID, IPAddress: "10.0.0.1"}
+	}
+	return dockerTypes.Container{
+		ID:              id,
+		Labels:          map[string]string{fixedIPLabel: "1"},
+		NetworkSettings: &dockerTypes.SummaryNetworkSettings{Networks: networks},
+	}
+}
+
+func TestEndpointIndex(t *testing.T) {
+	index := NewEndpointIndex(nil)
+	index.Add(newIndexedContainer("c1", "e1", "e2"))
+	index.Add(newIndexedContainer("c2", "e3"))
+	index.Add(dockerTypes.Container{ID: "c3"})
+
+	container, settings, ok := index.Get("e2")
+	require.True(t, ok)
+	assert.Equal(t, "c1", container.ID)
+	assert.Equal(t, "e2", settings.EndpointID)
+	assert.True(t, containerHasFixedIPLabel(container))
+
+	index.Forget("e2")
+	_, _, ok = index.Get("e2")
+	assert.False(t, ok)
+	_, _, ok = index.Get("e1")
+	assert.True(t, ok)
+
+	index.handle(context.Background(), events.Message{
+		Type:   events.ContainerEventType,
+		Action: "destroy",
+		Actor:  events.Actor{ID: "c1"},
+	})
+	_, _, ok = index.Get("e1")
+	assert.False(t, ok)
+	_, _, ok = index.Get("e3")
+	assert.True(t, ok)
+}
+
+func TestContainerFromInspect(t *testing.T) {
+	info := dockerTypes.ContainerJSON{
+		ContainerJSONBase: &dockerTypes.ContainerJSONBase{
+			ID:    "c1",
+			Name:  "/web",
+			State: &dockerTypes.ContainerState{Status: "running"},
+		},
+		Config: &container.Config{Labels: map[string]string{fixedIPLabel: "1"}},
+		NetworkSettings: &dockerTypes.NetworkSettings{
+			Networks: map[string]*dockerNetworkTypes.EndpointSettings{"calico": {EndpointID: "e1"}},
+		},
+	}
+	c, err := containerFromInspect(info)
+	require.NoError(t, err)
+	assert.Equal(t, "c1", c.ID)
+	assert.Equal(t, "running", c.State)
+	assert.Equal(t, "web", Identity{byName: true}.Of(c))
+	assert.True(t, containerHasFixedIPLabel(c))
+	assert.Equal(t, "e1", c.NetworkSettings.Networks["calico"].EndpointID)
+
+	_, err = containerFromInspect(dockerTypes.ContainerJSON{Config: info.Config})
+	assert.Error(t, err)
+}
@@ -3,14 +3,17 @@ package driver
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	dockerTypes "github.com/docker/docker/api/types"
 	"github.com/pkg/errors"
 	caliconet "github.com/projectcalico/libcalico-go/lib/net"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
-	fixedIPLabel = "fixed-ip"
+	fixedIPLabel    = "fixed-ip"
+	fixedIPTTLLabel = "fixed-ip-ttl"
 )
 
 func formatIPAddress(ip caliconet.IP) string {
@@ -35,3 +38,17 @@ func containerHasFixedIPLabel(container dockerTypes.Container) bool {
 	value, hasFixedIPLabel := container.Labels[fixedIPLabel]
 	return hasFixedIPLabel && strings.ToLower(value) != "false" && value != "0"
 }
+
+// reservationTTL returns ttl from fixed-ip-ttl label, or defaultTTL when label is absent or invalid
+func reservationTTL(container dockerTypes.Container, defaultTTL time.Duration) time.Duration {
+	value, ok := container.Labels[fixedIPTTLLabel]
+	if !ok {
+		return defaultTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		log.Warnf("Invalid %s label %q of container %s, using default ttl %v", fixedIPTTLLabel, value, container.ID, defaultTTL)
+		return defaultTTL
+	}
+	return ttl
+}
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"strings"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	identityByID          = "id"
+	identityByName        = "name"
+	identityByLabelPrefix = "label:"
+)
+
+// Identity decides the key which reserved ips of a container are recorded by.
+// Container ID changes on every redeploy, a label or the container name
+// lets the redeployed container get its previous ip back.
+type Identity struct {
+	byName bool
+	label  string
+}
+
+// ParseIdentity parses "id", "name" or "label:<label key>"
+func ParseIdentity(spec string) (Identity, error) {
+	switch {
+	case spec == "" || spec == identityByID:
+		return Identity{}, nil
+	case spec == identityByName:
+		return Identity{byName: true}, nil
+	case strings.HasPrefix(spec, identityByLabelPrefix) && len(spec) > len(identityByLabelPrefix):
+		return Identity{label: strings.TrimPrefix(spec, identityByLabelPrefix)}, nil
+	default:
+		return Identity{}, errors.Errorf("invalid identity %q, should be %s, %s or %s<label key>",
+			spec, identityByID, identityByName, identityByLabelPrefix)
+	}
+}
+
+// Of returns the identity of container, falls back to container ID when the name or label is absent
+func (i Identity) Of(container dockerTypes.Container) string {
+	switch {
+	case i.byName && len(container.Names) != 0:
+		return strings.TrimPrefix(container.Names[0], "/")
+	case i.label != "" && container.Labels[i.label] != "":
+		return container.Labels[i.label]
+	default:
+		return container.ID
+	}
+}
+
+// IsContainerID returns whether identities are always container IDs
+func (i Identity) IsContainerID() bool {
+	return !i.byName && i.label == ""
+}
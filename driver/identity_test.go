@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"testing"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentity(t *testing.T) {
+	container := dockerTypes.Container{
+		ID:     "abc",
+		Names:  []string{"/app_web_1"},
+		Labels: map[string]string{"eru.name": "app-web"},
+	}
+
+	identity, err := ParseIdentity("")
+	require.NoError(t, err)
+	assert.True(t, identity.IsContainerID())
+	assert.Equal(t, "abc", identity.Of(container))
+
+	identity, err = ParseIdentity("name")
+	require.NoError(t, err)
+	assert.Equal(t, "app_web_1", identity.Of(container))
+	assert.Equal(t, "abc", identity.Of(dockerTypes.Container{ID: "abc"}))
+
+	identity, err = ParseIdentity("label:eru.name")
+	require.NoError(t, err)
+	assert.False(t, identity.IsContainerID())
+	assert.Equal(t, "app-web", identity.Of(container))
+	assert.Equal(t, "abc", identity.Of(dockerTypes.Container{ID: "abc"}))
+
+	for _, spec := range []string{"label:", "names", "label"} {
+		_, err = ParseIdentity(spec)
+		assert.Error(t, err, spec)
+	}
+}
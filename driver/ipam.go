@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	dockerClient "github.com/docker/docker/client"
 	pluginIPAM "github.com/docker/go-plugins-helpers/ipam"
 	"github.com/pkg/errors"
 	"github.com/projectcalico/libcalico-go/lib/clientv3"
@@ -14,6 +18,7 @@ import (
 	barrelMeta "github.com/projecteru2/minions/barrel"
 	calDriver "github.com/projecteru2/minions/driver/calico"
 	calIpamDriver "github.com/projecteru2/minions/driver/calico/ipam"
+	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
 	"github.com/projecteru2/minions/types"
 
 	log "github.com/sirupsen/logrus"
@@ -22,17 +27,36 @@ import (
 // IPAMDriver .
 type IPAMDriver struct {
 	calicoIPAM *calIpamDriver.CalicoIPAM
+	dockerCli  *dockerClient.Client
 	meta       barrelMeta.Meta
+	reserveTTL time.Duration
+	identity   Identity
+
+	requestTimeout time.Duration
 }
 
 // NewIPAMDriver .
+// reserveTTL and identity are used for reservations which Leave failed to make,
+// identity also gets the reserved ips of redeployed containers back,
+// pools is shared with the network driver,
+// requestTimeout bounds calico, barrel and docker calls made for a plugin request, 0 means no deadline
 func NewIPAMDriver(
 	clientv3 clientv3.Interface,
+	pools *calDriver.PoolCache,
+	dockerCli *dockerClient.Client,
 	meta barrelMeta.Meta,
+	reserveTTL time.Duration,
+	identity Identity,
+	requestTimeout time.Duration,
 ) pluginIPAM.Ipam {
 	return &IPAMDriver{
-		calicoIPAM: calIpamDriver.NewCalicoIPAM(clientv3),
+		calicoIPAM: calIpamDriver.NewCalicoIPAM(clientv3, pools),
+		dockerCli:  dockerCli,
 		meta:       meta,
+		reserveTTL: reserveTTL,
+		identity:   identity,
+
+		requestTimeout: requestTimeout,
 	}
 }
 
@@ -56,41 +80,41 @@ func (i IPAMDriver) GetDefaultAddressSpaces() (*pluginIPAM.AddressSpacesResponse
 // RequestPool .
 func (i IPAMDriver) RequestPool(request *pluginIPAM.RequestPoolRequest) (*pluginIPAM.RequestPoolResponse, error) {
 	logutils.JSONMessage("RequestPool", request)
+	ctx, cancel := requestContext(i.requestTimeout)
+	defer cancel()
 
-	// Calico IPAM does not allow you to request a SubPool.
-	if request.SubPool != "" {
-		err := errors.New(
-			"Calico IPAM does not support sub pool configuration " +
-				"on 'docker create network'. Calico IP Pools " +
-				"should be configured first and IP assignment is " +
-				"from those pre-configured pools.",
-		)
-		log.Errorln(err)
-		return nil, err
-	}
-
-	if len(request.Options) != 0 {
-		err := errors.New("Arbitrary options are not supported")
-		log.Errorln(err)
+	opts, err := calIpamDriver.ParsePoolOptions(request.Options)
+	if err != nil {
+		log.Errorf("[IPAMDriver::RequestPool] parse ipam options error, %v", err)
 		return nil, err
 	}
 
-	var (
-		pool *types.Pool
-		err  error
-	)
+	var pool *types.Pool
 
 	// If a pool (subnet on the CLI) is specified, it must match one of the
 	// preconfigured Calico pools.
-	if request.Pool != "" {
-		if pool, err = i.calicoIPAM.RequestPool(request.Pool); err != nil {
+	// Pools selected by ipam options must also match the subnet when it's specified.
+	if !opts.IsEmpty() {
+		if pool, err = i.calicoIPAM.SelectPool(ctx, request.Pool, request.V6, opts); err != nil {
+			log.Errorf("[IPAMDriver::RequestPool] select calico pool error, %v", err)
+			return nil, requestError(ctx, "RequestPool", err)
+		}
+	} else if request.Pool != "" {
+		if pool, err = i.calicoIPAM.RequestPool(ctx, request.Pool); err != nil {
 			log.Errorf("[IPAMDriver::RequestPool] request calico pool error, %v", err)
-			return nil, err
+			return nil, requestError(ctx, "RequestPool", err)
 		}
 	} else {
 		pool = i.calicoIPAM.RequestDefaultPool(request.V6)
 	}
 
+	if request.SubPool != "" {
+		if err = i.restrictPool(ctx, pool, request.SubPool); err != nil {
+			log.Errorf("[IPAMDriver::RequestPool] restrict pool %s to sub pool %s error, %v", pool.Name, request.SubPool, err)
+			return nil, requestError(ctx, "RequestPool", err)
+		}
+	}
+
 	// We use static pool ID and CIDR. We don't need to signal the
 	// The meta data includes a dummy gateway address. This prevents libnetwork
 	// from requesting a gateway address from the pool since for a Calico
@@ -104,9 +128,46 @@ func (i IPAMDriver) RequestPool(request *pluginIPAM.RequestPoolRequest) (*plugin
 	return resp, nil
 }
 
-// ReleasePool .
+// restrictPool records the sub pool of docker --ip-range, which addresses of the pool are assigned from
+func (i IPAMDriver) restrictPool(ctx context.Context, pool *types.Pool, subPool string) error {
+	if pool.Name == calIpamDriver.PoolIDV4 || pool.Name == calIpamDriver.PoolIDV6 {
+		return errors.New("Sub pool requires a calico pool chosen by --subnet or ipam options")
+	}
+	_, poolNet, err := net.ParseCIDR(pool.CIDR)
+	if err != nil {
+		return err
+	}
+	_, subNet, err := net.ParseCIDR(subPool)
+	if err != nil {
+		return err
+	}
+	poolOnes, _ := poolNet.Mask.Size()
+	subOnes, _ := subNet.Mask.Size()
+	if !poolNet.Contains(subNet.IP) || subOnes < poolOnes {
+		return errors.Errorf("Sub pool %s is not inside pool %s(%s)", subPool, pool.Name, pool.CIDR)
+	}
+	return i.meta.PutSubPool(ctx, &types.SubPool{PoolID: pool.Name, CIDR: subNet.String()})
+}
+
+// ReleasePool lifts the sub pool restriction once the pool serves no docker network
 func (i IPAMDriver) ReleasePool(request *pluginIPAM.ReleasePoolRequest) error {
 	logutils.JSONMessage("ReleasePool", request)
+	if request.PoolID == calIpamDriver.PoolIDV4 || request.PoolID == calIpamDriver.PoolIDV6 {
+		return nil
+	}
+	ctx, cancel := requestContext(i.requestTimeout)
+	defer cancel()
+	pool, err := i.calicoIPAM.GetIPPool(ctx, request.PoolID)
+	if err != nil {
+		// the pool is gone, so is the need of its sub pool
+		log.Warnf("[IPAMDriver::ReleasePool] get pool %s error, %v", request.PoolID, err)
+	} else if len(calNetDriver.PoolNetworkIDs(pool)) != 0 {
+		return nil
+	}
+	if err = i.meta.DeleteSubPool(ctx, &types.SubPool{PoolID: request.PoolID}); err != nil {
+		log.Errorf("[IPAMDriver::ReleasePool] delete sub pool of %s error, %v", request.PoolID, err)
+		return requestError(ctx, "ReleasePool", err)
+	}
 	return nil
 }
 
@@ -120,16 +181,19 @@ func (i IPAMDriver) RequestAddress(request *pluginIPAM.RequestAddressRequest) (*
 		return nil, err
 	}
 
+	ctx, cancel := requestContext(i.requestTimeout)
+	defer cancel()
+
 	var address caliconet.IP
 	var err error
-	if address, err = i.requestIP(request); err != nil {
-		return nil, err
+	if address, err = i.requestIP(ctx, request); err != nil {
+		return nil, requestError(ctx, "RequestAddress", err)
 	}
 
 	// we should remove the request mark
 	ip := fmt.Sprintf("%v", address)
 	if _, err := i.meta.ConsumeRequestMarkIfPresent(
-		context.Background(),
+		ctx,
 		&types.ReserveRequest{
 			ReservedAddress: types.ReservedAddress{
 				PoolID:  request.PoolID,
@@ -138,7 +202,7 @@ func (i IPAMDriver) RequestAddress(request *pluginIPAM.RequestAddressRequest) (*
 		}); err != nil {
 		// Do not continue, or else the mark will cause some undefined behavior
 		log.Errorf("[IPAM.RequestAddress] remove request mark of ip(%v) error, %v", ip, err)
-		return nil, err
+		return nil, requestError(ctx, "RequestAddress", err)
 	}
 	log.Infof("[IPAM.RequestAddress] removed request mark on ip(%v) allocated", ip)
 
@@ -153,8 +217,15 @@ func (i IPAMDriver) RequestAddress(request *pluginIPAM.RequestAddressRequest) (*
 // ReleaseAddress .
 func (i IPAMDriver) ReleaseAddress(request *pluginIPAM.ReleaseAddressRequest) error {
 	logutils.JSONMessage("ReleaseAddress", request)
+	ctx, cancel := requestContext(i.requestTimeout)
+	defer cancel()
+	if err := i.reserveByEndpoint(ctx, request); err != nil {
+		// keep the ip assigned rather than losing a fixed ip
+		log.Errorf("[IPAMDriver::ReleaseAddress] reserve ip(%s) by endpoint record error, %v", request.Address, err)
+		return requestError(ctx, "ReleaseAddress", err)
+	}
 	reserved, err := i.meta.IPIsReserved(
-		context.Background(),
+		ctx,
 		&types.ReservedAddress{
 			PoolID:  request.PoolID,
 			Address: request.Address,
@@ -162,38 +233,187 @@ func (i IPAMDriver) ReleaseAddress(request *pluginIPAM.ReleaseAddressRequest) er
 	)
 	if err != nil {
 		log.Errorf("Get reserved ip status error, ip: %v", request.Address)
-		return err
+		return requestError(ctx, "ReleaseAddress", err)
 	}
 
 	if reserved {
 		log.Infof("Ip is reserved, will not release to pool, ip: %v\n", request.Address)
 		return nil
 	}
-	return i.calicoIPAM.ReleaseIP(request.PoolID, request.Address)
+	return requestError(ctx, "ReleaseAddress", i.calicoIPAM.ReleaseIP(ctx, request.PoolID, request.Address))
+}
+
+// reserveByEndpoint reserves the address by its endpoint record, which is left only when Leave failed
+// to decide or make the reservation, and removes the record once done
+func (i IPAMDriver) reserveByEndpoint(ctx context.Context, request *pluginIPAM.ReleaseAddressRequest) error {
+	endpoint := &types.Endpoint{PoolID: request.PoolID, Address: request.Address}
+	found, err := i.meta.GetEndpointByAddress(ctx, endpoint)
+	if err != nil || !found {
+		return err
+	}
+	if endpoint.ContainerID != "" {
+		// addresses of both versions are reserved at once, as the record is removed after
+		if err = reserveEndpointOnLeave(ctx, i.meta, i.identity, i.reserveTTL, endpoint); err != nil {
+			return err
+		}
+	}
+	return i.meta.DeleteEndpoint(ctx, endpoint)
 }
 
-func (i IPAMDriver) requestIP(request *pluginIPAM.RequestAddressRequest) (caliconet.IP, error) {
+func (i IPAMDriver) requestIP(ctx context.Context, request *pluginIPAM.RequestAddressRequest) (caliconet.IP, error) {
+	ipRange, err := i.subPoolOf(ctx, request.PoolID)
+	if err != nil {
+		return caliconet.IP{}, err
+	}
 	if request.Address == "" {
-		return i.calicoIPAM.AutoAssign(request.PoolID)
+		if address, acquired := i.acquireByIdentity(ctx, request.PoolID, ipRange); acquired {
+			return address, nil
+		}
+		if ipRange != nil {
+			return i.calicoIPAM.AssignFromRange(ctx, ipRange)
+		}
+		return i.calicoIPAM.AutoAssign(ctx, request.PoolID)
+	}
+	if ipRange != nil && !ipRange.Contains(net.ParseIP(request.Address)) {
+		return caliconet.IP{}, errors.Errorf("Address %s is out of the ip range %s of pool %s", request.Address, ipRange, request.PoolID)
 	}
-	var err error
 
 	// specified address requested, so will try assign from reserved pool, then calico pool
 	log.Info("Assigning specified IP from reserved pool first, then calico pools")
 
-	// try to acquire ip from reserved ip pool
+	// try to acquire ip from reserved ip pool.
+	// An explicit ip overrides its reservation whatever identity it's reserved for, it's how reserved ips
+	// are handed to containers by hand, e.g. when reservations are recorded by container ID.
+	reserved := &types.ReservedAddress{
+		PoolID:  request.PoolID,
+		Address: request.Address,
+	}
 	var acquired bool
-	if acquired, err = i.meta.AquireIfReserved(
-		context.Background(),
-		&types.ReservedAddress{
-			PoolID:  request.PoolID,
-			Address: request.Address,
-		}); err != nil {
+	if acquired, err = i.meta.AquireIfReserved(ctx, reserved); err != nil {
 		return caliconet.IP{}, err
 	}
 	if acquired {
+		log.Infof("[IPAMDriver::requestIP] acquired ip(%s) reserved for %s by explicit request", request.Address, reserved.ContainerID)
 		return caliconet.IP{IP: net.ParseIP(request.Address)}, nil
 	}
 	// assign IP from calico
-	return i.calicoIPAM.AssignIP(request.Address)
+	return i.calicoIPAM.AssignIP(ctx, request.Address)
+}
+
+// subPoolOf returns the ip range the pool is restricted to, nil when it's not restricted
+func (i IPAMDriver) subPoolOf(ctx context.Context, poolID string) (*net.IPNet, error) {
+	if poolID == calIpamDriver.PoolIDV4 || poolID == calIpamDriver.PoolIDV6 {
+		return nil, nil
+	}
+	subPool := &types.SubPool{PoolID: poolID}
+	found, err := i.meta.GetSubPool(ctx, subPool)
+	if err != nil || !found {
+		return nil, err
+	}
+	_, ipRange, err := net.ParseCIDR(subPool.CIDR)
+	return ipRange, err
+}
+
+// acquireByIdentity acquires the ip reserved in the pool for the identity of the container being started,
+// ips out of ipRange are skipped when it's not nil.
+// IPAM requests carry no container info, see startingContainer for how the container is found,
+// nothing is acquired when it can't be told.
+func (i IPAMDriver) acquireByIdentity(ctx context.Context, poolID string, ipRange *net.IPNet) (caliconet.IP, bool) {
+	if i.identity.IsContainerID() || poolID == calIpamDriver.PoolIDV4 || poolID == calIpamDriver.PoolIDV6 {
+		// container IDs never repeat, and containers of default pools can't be told apart by network
+		return caliconet.IP{}, false
+	}
+	container, found, err := i.startingContainer(ctx, poolID)
+	if err != nil {
+		log.Errorf("[IPAMDriver::acquireByIdentity] find container being started in pool %s error, %v", poolID, err)
+		return caliconet.IP{}, false
+	}
+	if !found || !containerHasFixedIPLabel(container) {
+		return caliconet.IP{}, false
+	}
+
+	info := &types.ContainerInfo{ID: i.identity.Of(container)}
+	if found, err = i.meta.GetContainerInfo(ctx, info); err != nil || !found {
+		if err != nil {
+			log.Errorf("[IPAMDriver::acquireByIdentity] get reserved ips of %s error, %v", info.ID, err)
+		}
+		return caliconet.IP{}, false
+	}
+	for _, address := range info.Addresses {
+		if address.PoolID != poolID {
+			continue
+		}
+		if ipRange != nil && !ipRange.Contains(net.ParseIP(address.Address)) {
+			continue
+		}
+		// only the reservation of the identity is acquired, in case it's released and reserved by another meanwhile
+		address.ContainerID = info.ID
+		acquired, err := i.meta.AquireIfReserved(ctx, &address)
+		if err != nil {
+			log.Errorf("[IPAMDriver::acquireByIdentity] acquire ip(%s) error, %v", address.Address, err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+		log.Infof("[IPAMDriver::acquireByIdentity] acquired reserved ip(%s) of %s for container %s", address.Address, info.ID, container.ID)
+		return caliconet.IP{IP: net.ParseIP(address.Address)}, true
+	}
+	return caliconet.IP{}, false
+}
+
+// startingContainer finds the container requesting an address of the pool.
+// Docker requests the address when it starts a created container, which is configured on a network
+// served by the pool and has no endpoint on it yet. The starting container is always such a container,
+// so it's known only when there is exactly one of them.
+// Running containers joined by docker network connect aren't listed with the network until connected,
+// they should be connected with --ip when unstarted containers of the network have reserved ips.
+func (i IPAMDriver) startingContainer(ctx context.Context, poolID string) (dockerTypes.Container, bool, error) {
+	pool, err := i.calicoIPAM.GetIPPool(ctx, poolID)
+	if err != nil {
+		return dockerTypes.Container{}, false, err
+	}
+	networks := make(map[string]bool)
+	for _, networkID := range calNetDriver.PoolNetworkIDs(pool) {
+		networkResource, err := i.dockerCli.NetworkInspect(ctx, networkID, dockerTypes.NetworkInspectOptions{})
+		if err != nil {
+			return dockerTypes.Container{}, false, err
+		}
+		networks[networkID] = true
+		networks[networkResource.Name] = true
+	}
+	containers, err := i.dockerCli.ContainerList(ctx, dockerTypes.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("status", "created")),
+	})
+	if err != nil {
+		return dockerTypes.Container{}, false, err
+	}
+	candidates := unconnectedContainers(containers, networks)
+	if len(candidates) != 1 {
+		log.Infof("[IPAMDriver::startingContainer] %d created containers wait for an address of pool %s, none is taken", len(candidates), poolID)
+		return dockerTypes.Container{}, false, nil
+	}
+	return candidates[0], true, nil
+}
+
+// unconnectedContainers returns containers configured on any of networks, keyed by ID and name,
+// without an endpoint on it yet
+func unconnectedContainers(containers []dockerTypes.Container, networks map[string]bool) []dockerTypes.Container {
+	var unconnected []dockerTypes.Container
+	for _, container := range containers {
+		if container.NetworkSettings == nil {
+			continue
+		}
+		for name, settings := range container.NetworkSettings.Networks {
+			if settings == nil || settings.EndpointID != "" {
+				continue
+			}
+			if networks[name] || (settings.NetworkID != "" && networks[settings.NetworkID]) {
+				unconnected = append(unconnected, container)
+				break
+			}
+		}
+	}
+	return unconnected
 }
@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerNetworkTypes "github.com/docker/docker/api/types/network"
+	pluginIPAM "github.com/docker/go-plugins-helpers/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel/memory"
+	calIpamDriver "github.com/projecteru2/minions/driver/calico/ipam"
+	"github.com/projecteru2/minions/types"
+)
+
+func TestSubPool(t *testing.T) {
+	driver := IPAMDriver{meta: memory.NewMemory()}
+	pool := &types.Pool{Name: "pool", CIDR: "10.0.0.0/16"}
+	ctx := context.Background()
+
+	ipRange, err := driver.subPoolOf(ctx, "pool")
+	require.NoError(t, err)
+	assert.Nil(t, ipRange)
+
+	assert.Error(t, driver.restrictPool(ctx, pool, "10.1.0.0/24"))
+	assert.Error(t, driver.restrictPool(ctx, pool, "10.0.0.0/8"))
+	assert.Error(t, driver.restrictPool(ctx, &types.Pool{Name: calIpamDriver.PoolIDV4, CIDR: "0.0.0.0/0"}, "10.0.0.0/24"))
+	require.NoError(t, driver.restrictPool(ctx, pool, "10.0.1.7/24"))
+	require.NoError(t, driver.restrictPool(ctx, pool, "10.0.1.0/24"))
+	assert.Equal(t, types.ErrSubPoolConflict, driver.restrictPool(ctx, pool, "10.0.2.0/24"))
+
+	ipRange, err = driver.subPoolOf(ctx, "pool")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.1.0/24", ipRange.String())
+	ipRange, err = driver.subPoolOf(ctx, calIpamDriver.PoolIDV4)
+	require.NoError(t, err)
+	assert.Nil(t, ipRange)
+
+	// explicit ips out of the range are refused before asking calico
+	_, err = driver.requestIP(ctx, &pluginIPAM.RequestAddressRequest{PoolID: "pool", Address: "10.0.2.1"})
+	assert.Error(t, err)
+
+	require.NoError(t, driver.meta.DeleteSubPool(ctx, &types.SubPool{PoolID: "pool"}))
+	require.NoError(t, driver.restrictPool(ctx, pool, "10.0.2.0/24"))
+}
+
+func TestUnconnectedContainers(t *testing.T) {
+	networks := map[string]bool{"n1": true, "net1": true}
+	containers := []dockerTypes.Container{
+		{ID: "c1", NetworkSettings: &dockerTypes.SummaryNetworkSettings{
+			Networks: map[string]*dockerNetworkTypes.EndpointSettings{"net1": {}},
+		}},
+		{ID: "c2", NetworkSettings: &dockerTypes.SummaryNetworkSettings{
+			Networks: map[string]*dockerNetworkTypes.EndpointSettings{"other": {NetworkID: "n1"}},
+		}},
+		// connected already
+		{ID: "c3", NetworkSettings: &dockerTypes.SummaryNetworkSettings{
+			Networks: map[string]*dockerNetworkTypes.EndpointSettings{"net1": {EndpointID: "e3"}},
+		}},
+		// of other networks
+		{ID: "c4", NetworkSettings: &dockerTypes.SummaryNetworkSettings{
+			Networks: map[string]*dockerNetworkTypes.EndpointSettings{"net2": {}},
+		}},
+		{ID: "c5"},
+	}
+
+	var ids []string
+	for _, container := range unconnectedContainers(containers, networks) {
+		ids = append(ids, container.ID)
+	}
+	assert.Equal(t, []string{"c1", "c2"}, ids)
+}
@@ -2,10 +2,13 @@ package driver
 
 import (
 	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/network"
 	"github.com/pkg/errors"
-	api "github.com/projectcalico/libcalico-go/lib/apis/v3"
 	"github.com/projectcalico/libcalico-go/lib/clientv3"
 	log "github.com/sirupsen/logrus"
 
@@ -15,28 +18,58 @@ import (
 	dockerClient "github.com/docker/docker/client"
 	logutils "github.com/projectcalico/libnetwork-plugin/utils/log"
 	"github.com/projecteru2/minions/barrel"
+	calDriver "github.com/projecteru2/minions/driver/calico"
 	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
 	"github.com/projecteru2/minions/types"
 )
 
+// endpointInfoReservedPrefix prefixes the keys of EndpointInfo response telling whether each ip is reserved,
+// e.g. "Reserved:10.0.0.1": "true"
+const endpointInfoReservedPrefix = "Reserved:"
+
 // NetworkDriver .
 type NetworkDriver struct {
 	calNetDriver calNetDriver.Driver
 	dockerCli    *dockerClient.Client
 	meta         barrel.Meta
+	reserveTTL   time.Duration
+	identity     Identity
+	endpoints    *EndpointIndex
+	owners       *ownerRecorder
+
+	requestTimeout time.Duration
 }
 
 // NewNetworkDriver .
+// reserveTTL is the default lifetime of reserved ips, 0 means never expire,
+// identity decides the key which reserved ips are recorded by,
+// endpoints resolves containers on Leave, it should be running,
+// requestTimeout bounds calico, barrel and docker calls made for a plugin request, 0 means no deadline
 func NewNetworkDriver(
 	client clientv3.Interface,
+	pools *calDriver.PoolCache,
 	dockerCli *dockerClient.Client,
 	meta barrel.Meta,
-) network.Driver {
+	reserveTTL time.Duration,
+	identity Identity,
+	endpoints *EndpointIndex,
+	requestTimeout time.Duration,
+) (network.Driver, error) {
+	calNet, err := calNetDriver.NewNetworkDriver(client, dockerCli, pools)
+	if err != nil {
+		return nil, err
+	}
 	return NetworkDriver{
-		calNetDriver: calNetDriver.NewNetworkDriver(client, dockerCli),
+		calNetDriver: calNet,
 		dockerCli:    dockerCli,
 		meta:         meta,
-	}
+		reserveTTL:   reserveTTL,
+		identity:     identity,
+		endpoints:    endpoints,
+		owners:       newOwnerRecorder(),
+
+		requestTimeout: requestTimeout,
+	}, nil
 }
 
 // GetCapabilities .
@@ -57,119 +90,292 @@ func (driver NetworkDriver) FreeNetwork(request *network.FreeNetworkRequest) err
 
 // CreateNetwork .
 func (driver NetworkDriver) CreateNetwork(request *network.CreateNetworkRequest) error {
-	return driver.calNetDriver.CreateNetwork(request)
+	ctx, cancel := requestContext(driver.requestTimeout)
+	defer cancel()
+	return requestError(ctx, "CreateNetwork", driver.calNetDriver.CreateNetwork(ctx, request))
 }
 
 // DeleteNetwork .
 func (driver NetworkDriver) DeleteNetwork(request *network.DeleteNetworkRequest) error {
-	return driver.calNetDriver.DeleteNetwork(request)
+	ctx, cancel := requestContext(driver.requestTimeout)
+	defer cancel()
+	// the pool can't be found by network after calico removed the mapping
+	pool, err := driver.calNetDriver.FindPoolByNetworkID(ctx, request.NetworkID)
+	if err != nil {
+		log.Warnf("[NetworkDriver::DeleteNetwork] find pool of network %s error, %v", request.NetworkID, err)
+	}
+	if err = driver.calNetDriver.DeleteNetwork(ctx, request); err != nil {
+		return requestError(ctx, "DeleteNetwork", err)
+	}
+	// reservations are still in use by other networks on the pool
+	if pool != nil && len(calNetDriver.PoolNetworkIDs(pool)) == 1 {
+		driver.warnReservations(ctx, pool.Name)
+	}
+	return nil
+}
+
+// warnReservations warns about reservations and marks left in the pool, they are kept for a network
+// recreated on the pool, and should be released by operators otherwise
+func (driver NetworkDriver) warnReservations(ctx context.Context, poolID string) {
+	addresses, err := driver.meta.ListReservedAddresses(ctx, poolID)
+	if err != nil {
+		log.Errorf("[NetworkDriver::warnReservations] list reserved ips of pool %s error, %v", poolID, err)
+	} else if len(addresses) != 0 {
+		log.Warnf("[NetworkDriver::warnReservations] %d reserved ips are left in pool %s of the deleted network", len(addresses), poolID)
+	}
+	requests, err := driver.meta.ListReserveRequests(ctx, poolID)
+	if err != nil {
+		log.Errorf("[NetworkDriver::warnReservations] list reserve request marks of pool %s error, %v", poolID, err)
+	} else if len(requests) != 0 {
+		log.Warnf("[NetworkDriver::warnReservations] %d reserve request marks are left in pool %s of the deleted network", len(requests), poolID)
+	}
 }
 
 // CreateEndpoint .
 func (driver NetworkDriver) CreateEndpoint(request *network.CreateEndpointRequest) (*network.CreateEndpointResponse, error) {
-	return driver.calNetDriver.CreateEndpoint(request)
+	ctx, cancel := requestContext(driver.requestTimeout)
+	defer cancel()
+	resp, err := driver.calNetDriver.CreateEndpoint(ctx, request)
+	if err != nil {
+		return nil, requestError(ctx, "CreateEndpoint", err)
+	}
+	driver.recordEndpoint(ctx, request)
+	return resp, nil
 }
 
 // DeleteEndpoint .
 func (driver NetworkDriver) DeleteEndpoint(request *network.DeleteEndpointRequest) error {
-	return driver.calNetDriver.DeleteEndpoint(request)
+	ctx, cancel := requestContext(driver.requestTimeout)
+	defer cancel()
+	return requestError(ctx, "DeleteEndpoint", driver.calNetDriver.DeleteEndpoint(ctx, request))
 }
 
-// EndpointInfo .
+// EndpointInfo adds whether each ip is reserved in barrel to the calico endpoint info
 func (driver NetworkDriver) EndpointInfo(request *network.InfoRequest) (*network.InfoResponse, error) {
-	return driver.calNetDriver.EndpointInfo(request)
+	ctx, cancel := requestContext(driver.requestTimeout)
+	defer cancel()
+	resp, err := driver.calNetDriver.EndpointInfo(ctx, request)
+	if err != nil {
+		return nil, requestError(ctx, "EndpointInfo", err)
+	}
+	for _, ipNetwork := range strings.Split(resp.Value[calNetDriver.EndpointInfoIPNetworks], ",") {
+		if ipNetwork == "" {
+			continue
+		}
+		// IPv4 and IPv6 addresses come from different pools, so each is looked up in its own
+		poolID, ip, err := driver.poolAddressOf(ctx, request.NetworkID, ipNetwork)
+		if err != nil {
+			log.Errorf("[NetworkDriver::EndpointInfo] find pool of ip(%s) error, %v", ipNetwork, err)
+			continue
+		}
+		reserved, err := driver.meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: poolID, Address: ip})
+		if err != nil {
+			log.Errorf("[NetworkDriver::EndpointInfo] get reserved status of ip(%s) error, %v", ip, err)
+			continue
+		}
+		resp.Value[endpointInfoReservedPrefix+ip] = strconv.FormatBool(reserved)
+	}
+	return resp, nil
 }
 
 // Join .
 func (driver NetworkDriver) Join(request *network.JoinRequest) (*network.JoinResponse, error) {
-	return driver.calNetDriver.Join(request)
+	ctx, cancel := requestContext(driver.requestTimeout)
+	defer cancel()
+	resp, err := driver.calNetDriver.Join(ctx, request)
+	if err != nil {
+		return nil, requestError(ctx, "Join", err)
+	}
+	driver.owners.start(request.EndpointID, func(ctx context.Context) error {
+		return driver.recordEndpointOwner(ctx, request.EndpointID, request.NetworkID)
+	})
+	return resp, nil
 }
 
 // Leave .
 func (driver NetworkDriver) Leave(request *network.LeaveRequest) error {
 	logutils.JSONMessage("Leave response", request)
-	var (
-		container        dockerTypes.Container
-		endpointSettings *dockerNetworkTypes.EndpointSettings
-		pool             *api.IPPool
-		shouldReserveIP  bool
-		err              error
-	)
-	if container, endpointSettings, err = driver.findDockerContainerByEndpointID(request.EndpointID); err != nil {
-		return err
-	}
-
-	if pool, err = driver.calNetDriver.FindPoolByNetworkID(endpointSettings.NetworkID); err != nil {
-		return err
-	}
-
-	if shouldReserveIP, err = driver.shouldReserveIP(
-		container,
-		&types.ReserveRequest{
-			ReservedAddress: types.ReservedAddress{
-				PoolID:  pool.Name,
-				Address: endpointSettings.IPAddress,
-			},
-		}); err != nil {
-		// we move on when trying to find out whether should reserve by reserve request mark
-		log.Errorln(err)
+	ctx, cancel := requestContext(driver.requestTimeout)
+	defer cancel()
+	if err := driver.owners.finish(request.EndpointID); err != nil {
+		log.Warnf("[NetworkDriver::Leave] owner of endpoint %s is not recorded, resolving it from docker, %v", request.EndpointID, err)
 	}
-	if shouldReserveIP {
-		if err = driver.meta.ReserveIPforContainer(
-			context.Background(),
-			&types.ReservedAddress{
-				PoolID:  pool.Name,
-				Address: endpointSettings.IPAddress,
-			}, container.ID); err != nil {
-			// we move on when reserve is failed
-			log.Errorln(err)
-		}
+	endpoint, err := driver.resolveEndpoint(ctx, request.EndpointID)
+	if err != nil {
+		return requestError(ctx, "Leave", err)
+	}
+	driver.endpoints.Forget(request.EndpointID)
+
+	if err = reserveEndpointOnLeave(ctx, driver.meta, driver.identity, driver.reserveTTL, endpoint); err != nil {
+		// we move on when reserve is failed, the record is kept for ReleaseAddress to retry
+		log.Errorln(err)
+	} else if err = driver.meta.DeleteEndpoint(ctx, endpoint); err != nil {
+		log.Errorf("[NetworkDriver::Leave] delete record of endpoint %s error, %v", endpoint.ID, err)
 	}
 	return driver.calNetDriver.Leave(request)
 }
 
-func (driver NetworkDriver) findDockerContainerByEndpointID(endpointID string) (dockerTypes.Container, *dockerNetworkTypes.EndpointSettings, error) {
-	containers, err := driver.dockerCli.ContainerList(context.Background(), dockerTypes.ContainerListOptions{})
+// recordEndpoint saves the endpoint record, its owner is filled after Join
+func (driver NetworkDriver) recordEndpoint(ctx context.Context, request *network.CreateEndpointRequest) {
+	endpoint := &types.Endpoint{
+		ID:        request.EndpointID,
+		NetworkID: request.NetworkID,
+	}
+	var err error
+	if endpoint.PoolID, endpoint.Address, err = driver.poolAddressOf(ctx, request.NetworkID, request.Interface.Address); err != nil {
+		log.Errorf("[NetworkDriver::recordEndpoint] resolve IPv4 address of endpoint %s error, %v", request.EndpointID, err)
+		return
+	}
+	if endpoint.PoolIDIPv6, endpoint.AddressIPv6, err = driver.poolAddressOf(ctx, request.NetworkID, request.Interface.AddressIPv6); err != nil {
+		log.Errorf("[NetworkDriver::recordEndpoint] resolve IPv6 address of endpoint %s error, %v", request.EndpointID, err)
+		return
+	}
+	if err = driver.meta.PutEndpoint(ctx, endpoint); err != nil {
+		log.Errorf("[NetworkDriver::recordEndpoint] save record of endpoint %s error, %v", request.EndpointID, err)
+	}
+}
+
+// poolAddressOf returns the pool and the ip of address in CIDR form, both are blank when address is blank
+func (driver NetworkDriver) poolAddressOf(ctx context.Context, networkID, address string) (string, string, error) {
+	if address == "" {
+		return "", "", nil
+	}
+	ip, _, err := net.ParseCIDR(address)
 	if err != nil {
-		log.Errorf("dockerCli ContainerList Error, %v", err)
-		return dockerTypes.Container{}, nil, err
+		return "", "", err
 	}
-	for _, container := range containers {
-		for _, network := range container.NetworkSettings.Networks {
-			if endpointID == network.EndpointID {
-				return container, network, nil
+	pool, err := driver.calNetDriver.FindPoolByAddress(ctx, networkID, ip)
+	if err != nil {
+		return "", "", err
+	}
+	return pool.Name, ip.String(), nil
+}
+
+// recordEndpointOwner fills the container into the endpoint record.
+// Docker knows the container of the endpoint only after Join returns, so it polls until ctx is done.
+func (driver NetworkDriver) recordEndpointOwner(ctx context.Context, endpointID, networkID string) error {
+	ticker := time.NewTicker(ownerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if container, ok := driver.findEndpointOwner(ctx, endpointID, networkID); ok {
+			endpoint := &types.Endpoint{ID: endpointID}
+			found, err := driver.meta.GetEndpoint(ctx, endpoint)
+			if err != nil {
+				return errors.Wrapf(err, "get record of endpoint %s", endpointID)
+			}
+			if !found {
+				return errors.Errorf("record of endpoint %s not found", endpointID)
+			}
+			endpoint.ContainerID = container.ID
+			if len(container.Names) != 0 {
+				endpoint.ContainerName = container.Names[0]
 			}
+			endpoint.Labels = container.Labels
+			return errors.Wrapf(driver.meta.PutEndpoint(ctx, endpoint), "save record of endpoint %s", endpointID)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "find container of endpoint %s", endpointID)
+		case <-ticker.C:
 		}
 	}
-	return dockerTypes.Container{}, nil, errors.Errorf("find no container with endpintID = %s", endpointID)
 }
 
-func (driver NetworkDriver) shouldReserveIP(container dockerTypes.Container, address *types.ReserveRequest) (shouldReserve bool, err error) {
-	// reserve ip here by container label
-	if containerHasFixedIPLabel(container) {
-		shouldReserve = true
-		// we should consume the mark
-		if _, err := driver.meta.ConsumeRequestMarkIfPresent(context.Background(), address); err != nil {
-			log.Errorf("[Network.ConsumeRequestMarkIfPresent] remove request mark error, %v", err)
+// findEndpointOwner looks up the index first, then the containers of the network
+func (driver NetworkDriver) findEndpointOwner(ctx context.Context, endpointID, networkID string) (dockerTypes.Container, bool) {
+	if container, _, ok := driver.endpoints.Get(endpointID); ok {
+		return container, true
+	}
+	networkResource, err := driver.dockerCli.NetworkInspect(ctx, networkID, dockerTypes.NetworkInspectOptions{})
+	if err != nil {
+		log.Warnf("[NetworkDriver::findEndpointOwner] inspect network %s error, %v", networkID, err)
+		return dockerTypes.Container{}, false
+	}
+	for containerID, resource := range networkResource.Containers {
+		// skip the "ep-" entries of endpoints without containers
+		if resource.EndpointID != endpointID || strings.HasPrefix(containerID, "ep-") {
+			continue
 		}
-		log.Infof("[Network.ConsumeRequestMarkIfPresent] container has fixed-ip label, shouldReserve ip(%v) = %v", address, shouldReserve)
-		return
+		info, err := driver.dockerCli.ContainerInspect(ctx, containerID)
+		if err != nil {
+			log.Warnf("[NetworkDriver::findEndpointOwner] inspect container %s error, %v", containerID, err)
+			return dockerTypes.Container{}, false
+		}
+		container, err := containerFromInspect(info)
+		if err != nil {
+			log.Warnf("[NetworkDriver::findEndpointOwner] container %s, %v", containerID, err)
+			return dockerTypes.Container{}, false
+		}
+		driver.endpoints.Add(container)
+		return container, true
 	}
-	// reserve ip here by reserve request mark
-	if shouldReserve, err = driver.meta.ConsumeRequestMarkIfPresent(context.Background(), address); err != nil {
-		// ensure shouldReserve is false here when err is not nil
-		log.Errorf("[Network.ConsumeRequestMarkIfPresent] error, %v", err)
-		shouldReserve = false
-		return
+	return dockerTypes.Container{}, false
+}
+
+// resolveEndpoint returns the endpoint record with its owner,
+// it falls back to docker when the record or its owner is missing
+func (driver NetworkDriver) resolveEndpoint(ctx context.Context, endpointID string) (*types.Endpoint, error) {
+	endpoint := &types.Endpoint{ID: endpointID}
+	found, err := driver.meta.GetEndpoint(ctx, endpoint)
+	if err != nil {
+		log.Errorf("[NetworkDriver::resolveEndpoint] get record of endpoint %s error, %v", endpointID, err)
 	}
-	var msg string
-	if shouldReserve {
-		msg = "marked as requested"
-	} else {
-		msg = "not marked as requested"
+	if found && endpoint.ContainerID != "" {
+		return endpoint, nil
 	}
-	log.Infof("[Network.ConsumeRequestMarkIfPresent] address is %s, shouldReserve ip(%v) = %v", msg, address, shouldReserve)
-	return
+
+	container, endpointSettings, err := driver.findDockerContainerByEndpointID(ctx, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	endpoint = &types.Endpoint{
+		ID:          endpointID,
+		NetworkID:   endpointSettings.NetworkID,
+		ContainerID: container.ID,
+		Labels:      container.Labels,
+	}
+	if endpointSettings.IPAddress != "" {
+		ip := net.ParseIP(endpointSettings.IPAddress)
+		pool, err := driver.calNetDriver.FindPoolByAddress(ctx, endpointSettings.NetworkID, ip)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.PoolID, endpoint.Address = pool.Name, endpointSettings.IPAddress
+	}
+	if endpointSettings.GlobalIPv6Address != "" {
+		ip := net.ParseIP(endpointSettings.GlobalIPv6Address)
+		pool, err := driver.calNetDriver.FindPoolByAddress(ctx, endpointSettings.NetworkID, ip)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.PoolIDIPv6, endpoint.AddressIPv6 = pool.Name, endpointSettings.GlobalIPv6Address
+	}
+	if len(container.Names) != 0 {
+		endpoint.ContainerName = container.Names[0]
+	}
+	return endpoint, nil
+}
+
+// findDockerContainerByEndpointID resolves the endpoint by the index,
+// and falls back to listing containers when the index has not caught up
+func (driver NetworkDriver) findDockerContainerByEndpointID(ctx context.Context, endpointID string) (dockerTypes.Container, *dockerNetworkTypes.EndpointSettings, error) {
+	if container, settings, ok := driver.endpoints.Get(endpointID); ok {
+		return container, settings, nil
+	}
+	log.Warnf("[NetworkDriver::findDockerContainerByEndpointID] endpoint %s is not indexed, listing containers", endpointID)
+	containers, err := driver.dockerCli.ContainerList(ctx, dockerTypes.ContainerListOptions{All: true})
+	if err != nil {
+		log.Errorf("dockerCli ContainerList Error, %v", err)
+		return dockerTypes.Container{}, nil, err
+	}
+	for _, container := range containers {
+		driver.endpoints.Add(container)
+	}
+	if container, settings, ok := driver.endpoints.Get(endpointID); ok {
+		return container, settings, nil
+	}
+	return dockerTypes.Container{}, nil, errors.Errorf("find no container with endpintID = %s", endpointID)
 }
 
 // DiscoverNew .
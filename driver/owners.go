@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"context"
+	"sync"
+)
+
+type ownerRecording struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// ownerRecorder tracks the owner recordings started on Join.
+// Docker holds the container while joining, so the owner can only be recorded after Join returns,
+// a failed recording is kept until Leave of the endpoint collects it
+type ownerRecorder struct {
+	mu      sync.Mutex
+	pending map[string]*ownerRecording
+}
+
+func newOwnerRecorder() *ownerRecorder {
+	return &ownerRecorder{pending: make(map[string]*ownerRecording)}
+}
+
+// start runs record for the endpoint in background, a recording started before is canceled
+func (r *ownerRecorder) start(endpointID string, record func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ownerPollTimeout)
+	recording := &ownerRecording{cancel: cancel, done: make(chan struct{})}
+
+	r.mu.Lock()
+	previous := r.pending[endpointID]
+	r.pending[endpointID] = recording
+	r.mu.Unlock()
+	if previous != nil {
+		previous.cancel()
+	}
+
+	go func() {
+		defer close(recording.done)
+		defer cancel()
+		if recording.err = record(ctx); recording.err != nil {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.pending[endpointID] == recording {
+			delete(r.pending, endpointID)
+		}
+	}()
+}
+
+// finish cancels the recording of the endpoint, waits for it to stop and returns its error,
+// so it can't write the record after Leave. It returns nil when the owner is recorded
+func (r *ownerRecorder) finish(endpointID string) error {
+	r.mu.Lock()
+	recording := r.pending[endpointID]
+	delete(r.pending, endpointID)
+	r.mu.Unlock()
+	if recording == nil {
+		return nil
+	}
+	recording.cancel()
+	<-recording.done
+	return recording.err
+}
@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnerRecorder(t *testing.T) {
+	recorder := newOwnerRecorder()
+
+	// recorded owners leave nothing to collect
+	recorded := make(chan struct{})
+	recorder.start("e1", func(ctx context.Context) error {
+		defer close(recorded)
+		return nil
+	})
+	<-recorded
+	assert.Eventually(t, func() bool {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		return len(recorder.pending) == 0
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, recorder.finish("e1"))
+
+	// failures are kept for Leave
+	recorder.start("e2", func(ctx context.Context) error {
+		return errors.New("save record")
+	})
+	assert.EqualError(t, recorder.finish("e2"), "save record")
+	assert.NoError(t, recorder.finish("e2"))
+
+	// pending recordings are canceled and stopped before Leave goes on
+	stopped := false
+	recorder.start("e3", func(ctx context.Context) error {
+		<-ctx.Done()
+		stopped = true
+		return ctx.Err()
+	})
+	assert.Equal(t, context.Canceled, recorder.finish("e3"))
+	assert.True(t, stopped)
+
+	assert.NoError(t, recorder.finish("e4"))
+}
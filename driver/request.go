@@ -0,0 +1,26 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// requestContext returns the context of a plugin request, calico, barrel and docker calls made for it
+// give up once timeout passes, so a hung datastore fails docker fast instead of blocking it.
+// timeout 0 means no deadline.
+func requestContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// requestError tells docker users the request timed out, instead of only the error of the call it was in
+func requestError(ctx context.Context, method string, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	return errors.Wrapf(err, "%s timed out, calico datastore, barrel or docker didn't respond in time", method)
+}
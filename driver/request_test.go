@@ -0,0 +1,33 @@
+package driver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestContext(t *testing.T) {
+	ctx, cancel := requestContext(0)
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+	cancel()
+
+	ctx, cancel = requestContext(time.Minute)
+	defer cancel()
+	_, hasDeadline = ctx.Deadline()
+	assert.True(t, hasDeadline)
+
+	callErr := errors.New("etcd unavailable")
+	assert.NoError(t, requestError(ctx, "Join", nil))
+	assert.Equal(t, callErr, requestError(ctx, "Join", callErr))
+
+	expired, cancelExpired := requestContext(time.Nanosecond)
+	defer cancelExpired()
+	<-expired.Done()
+	err := requestError(expired, "Join", callErr)
+	assert.True(t, strings.HasPrefix(err.Error(), "Join timed out"))
+	assert.Contains(t, err.Error(), callErr.Error())
+}
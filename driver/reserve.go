@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projecteru2/minions/barrel"
+	"github.com/projecteru2/minions/types"
+)
+
+const (
+	// ownerPollTimeout is how long to wait for docker to know the container of a joined endpoint
+	ownerPollTimeout  = 30 * time.Second
+	ownerPollInterval = 100 * time.Millisecond
+)
+
+// reserveOnLeave reserves the address of the leaving container when it's labeled with fixed-ip
+// or the address is marked by a reserve request, the mark is consumed by the reservation,
+// so it's still there for a retry when either the check or the reservation fails
+func reserveOnLeave(
+	ctx context.Context,
+	meta barrel.Meta,
+	identity Identity,
+	reserveTTL time.Duration,
+	container dockerTypes.Container,
+	address types.ReservedAddress,
+) error {
+	shouldReserve, err := shouldReserveIP(ctx, meta, container, &types.ReserveRequest{ReservedAddress: address})
+	if err != nil || !shouldReserve {
+		return err
+	}
+	if ttl := reservationTTL(container, reserveTTL); ttl > 0 {
+		address.ExpireAt = time.Now().Add(ttl)
+	}
+	return meta.ReserveIPforContainer(ctx, &address, identity.Of(container))
+}
+
+// reserveEndpointOnLeave reserves IPv4 and IPv6 addresses of the endpoint by reserveOnLeave,
+// it tries all addresses and returns the first error
+func reserveEndpointOnLeave(
+	ctx context.Context,
+	meta barrel.Meta,
+	identity Identity,
+	reserveTTL time.Duration,
+	endpoint *types.Endpoint,
+) error {
+	var firstErr error
+	container := containerOfEndpoint(endpoint)
+	for _, address := range endpoint.Addresses() {
+		if err := reserveOnLeave(ctx, meta, identity, reserveTTL, container, address); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func shouldReserveIP(ctx context.Context, meta barrel.Meta, container dockerTypes.Container, address *types.ReserveRequest) (shouldReserve bool, err error) {
+	// reserve ip here by container label
+	if containerHasFixedIPLabel(container) {
+		log.Infof("[Network.shouldReserveIP] container has fixed-ip label, shouldReserve ip(%v) = true", address)
+		return true, nil
+	}
+	// reserve ip here by reserve request mark, which is consumed along with the reservation
+	if shouldReserve, err = meta.RequestMarkIsPresent(ctx, address); err != nil {
+		log.Errorf("[Network.shouldReserveIP] check request mark error, %v", err)
+		return false, err
+	}
+	var msg string
+	if shouldReserve {
+		msg = "marked as requested"
+	} else {
+		msg = "not marked as requested"
+	}
+	log.Infof("[Network.shouldReserveIP] address is %s, shouldReserve ip(%v) = %v", msg, address, shouldReserve)
+	return
+}
+
+// containerOfEndpoint rebuilds the owner container from the endpoint record
+func containerOfEndpoint(endpoint *types.Endpoint) dockerTypes.Container {
+	container := dockerTypes.Container{
+		ID:     endpoint.ContainerID,
+		Labels: endpoint.Labels,
+	}
+	if endpoint.ContainerName != "" {
+		container.Names = []string{endpoint.ContainerName}
+	}
+	return container
+}
@@ -0,0 +1,166 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	pluginIPAM "github.com/docker/go-plugins-helpers/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel"
+	"github.com/projecteru2/minions/barrel/memory"
+	"github.com/projecteru2/minions/types"
+)
+
+func TestReserveOnLeave(t *testing.T) {
+	ctx := context.Background()
+	meta := memory.NewMemory()
+	identity, err := ParseIdentity("name")
+	require.NoError(t, err)
+	address := types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"}
+
+	// neither labeled nor marked
+	require.NoError(t, reserveOnLeave(ctx, meta, identity, 0, dockerTypes.Container{ID: "c1"}, address))
+	reserved, err := meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, reserved)
+
+	// marked
+	require.NoError(t, meta.MarkReserveRequest(ctx, &types.ReserveRequest{ReservedAddress: address}))
+	require.NoError(t, reserveOnLeave(ctx, meta, identity, 0, dockerTypes.Container{ID: "c1", Names: []string{"/web"}}, address))
+	got := &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"}
+	reserved, err = meta.IPIsReserved(ctx, got)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Equal(t, "web", got.ContainerID)
+	assert.True(t, got.ExpireAt.IsZero())
+	marked, err := meta.RequestMarkIsPresent(ctx, &types.ReserveRequest{ReservedAddress: address})
+	require.NoError(t, err)
+	assert.False(t, marked)
+
+	// labeled with ttl
+	container := dockerTypes.Container{ID: "c2", Labels: map[string]string{fixedIPLabel: "1", fixedIPTTLLabel: "1h"}}
+	address.Address = "10.0.0.2"
+	require.NoError(t, reserveOnLeave(ctx, meta, identity, 0, container, address))
+	got = &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.2"}
+	reserved, err = meta.IPIsReserved(ctx, got)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Equal(t, "c2", got.ContainerID)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), got.ExpireAt, time.Minute)
+}
+
+// failingMeta fails the reservation or the mark check with err
+type failingMeta struct {
+	barrel.Meta
+	reserveErr error
+	markErr    error
+}
+
+func (m failingMeta) ReserveIPforContainer(ctx context.Context, address *types.ReservedAddress, ID string) error {
+	if m.reserveErr != nil {
+		return m.reserveErr
+	}
+	return m.Meta.ReserveIPforContainer(ctx, address, ID)
+}
+
+func (m failingMeta) RequestMarkIsPresent(ctx context.Context, request *types.ReserveRequest) (bool, error) {
+	if m.markErr != nil {
+		return false, m.markErr
+	}
+	return m.Meta.RequestMarkIsPresent(ctx, request)
+}
+
+func TestReserveOnLeaveKeepsMarkOnFailure(t *testing.T) {
+	ctx := context.Background()
+	meta := memory.NewMemory()
+	identity, err := ParseIdentity("id")
+	require.NoError(t, err)
+	container := dockerTypes.Container{ID: "c1"}
+	address := types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"}
+	request := &types.ReserveRequest{ReservedAddress: address}
+	require.NoError(t, meta.MarkReserveRequest(ctx, request))
+
+	err = reserveOnLeave(ctx, failingMeta{Meta: meta, markErr: errors.New("mark")}, identity, 0, container, address)
+	assert.EqualError(t, err, "mark")
+	err = reserveOnLeave(ctx, failingMeta{Meta: meta, reserveErr: errors.New("reserve")}, identity, 0, container, address)
+	assert.EqualError(t, err, "reserve")
+	marked, err := meta.RequestMarkIsPresent(ctx, request)
+	require.NoError(t, err)
+	assert.True(t, marked)
+
+	// the retry still sees the mark
+	require.NoError(t, reserveOnLeave(ctx, meta, identity, 0, container, address))
+	reserved, err := meta.IPIsReserved(ctx, &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	marked, err = meta.RequestMarkIsPresent(ctx, request)
+	require.NoError(t, err)
+	assert.False(t, marked)
+}
+
+func TestReserveByEndpoint(t *testing.T) {
+	ctx := context.Background()
+	meta := memory.NewMemory()
+	driver := IPAMDriver{meta: meta}
+
+	// Leave failed to reserve the ip of a fixed-ip container
+	endpoint := &types.Endpoint{
+		ID:          "e1",
+		PoolID:      "pool",
+		Address:     "10.0.0.1",
+		ContainerID: "c1",
+		Labels:      map[string]string{fixedIPLabel: "1"},
+	}
+	require.NoError(t, meta.PutEndpoint(ctx, endpoint))
+	// Leave never ran for the endpoint without owner
+	require.NoError(t, meta.PutEndpoint(ctx, &types.Endpoint{ID: "e2", PoolID: "pool", Address: "10.0.0.2"}))
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		require.NoError(t, driver.reserveByEndpoint(ctx, &pluginIPAM.ReleaseAddressRequest{PoolID: "pool", Address: ip}))
+	}
+
+	addresses, err := meta.ListReservedAddresses(ctx, "pool")
+	require.NoError(t, err)
+	require.Len(t, addresses, 1)
+	assert.Equal(t, "10.0.0.1", addresses[0].Address)
+	assert.Equal(t, "c1", addresses[0].ContainerID)
+
+	for _, id := range []string{"e1", "e2"} {
+		found, err := meta.GetEndpoint(ctx, &types.Endpoint{ID: id})
+		require.NoError(t, err)
+		assert.False(t, found)
+	}
+}
+
+func TestReserveDualStackByEndpoint(t *testing.T) {
+	ctx := context.Background()
+	meta := memory.NewMemory()
+	driver := IPAMDriver{meta: meta}
+
+	endpoint := &types.Endpoint{
+		ID:          "e1",
+		PoolID:      "pool",
+		Address:     "10.0.0.1",
+		PoolIDIPv6:  "pool6",
+		AddressIPv6: "fd00::1",
+		ContainerID: "c1",
+		Labels:      map[string]string{fixedIPLabel: "1"},
+	}
+	require.NoError(t, meta.PutEndpoint(ctx, endpoint))
+
+	// releasing either address reserves both
+	require.NoError(t, driver.reserveByEndpoint(ctx, &pluginIPAM.ReleaseAddressRequest{PoolID: "pool6", Address: "fd00::1"}))
+	for _, address := range endpoint.Addresses() {
+		reserved, err := meta.IPIsReserved(ctx, &address)
+		require.NoError(t, err)
+		assert.True(t, reserved, address.Address)
+	}
+	found, err := meta.GetEndpointByAddress(ctx, &types.Endpoint{PoolID: "pool", Address: "10.0.0.1"})
+	require.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,243 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/libcalico-go/lib/clientv3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projecteru2/minions/barrel"
+	calDriver "github.com/projecteru2/minions/driver/calico"
+	calIpamDriver "github.com/projecteru2/minions/driver/calico/ipam"
+	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
+	"github.com/projecteru2/minions/types"
+)
+
+// Sweeper removes host side veths and workload endpoints of this node left behind by crashes
+// between Join and Leave, which docker no longer knows
+type Sweeper struct {
+	calNetDriver calNetDriver.Driver
+	calicoIPAM   *calIpamDriver.CalicoIPAM
+	dockerCli    *dockerClient.Client
+	meta         barrel.Meta
+	reserveTTL   time.Duration
+	identity     Identity
+
+	// suspects are orphans found by the last sweep, they are removed when found again,
+	// so endpoints being created or joined are never taken as orphans
+	suspects map[string]bool
+}
+
+// NewSweeper .
+// reserveTTL and identity are used to reserve ips of fixed-ip containers found in orphaned endpoints
+func NewSweeper(
+	client clientv3.Interface,
+	pools *calDriver.PoolCache,
+	dockerCli *dockerClient.Client,
+	meta barrel.Meta,
+	reserveTTL time.Duration,
+	identity Identity,
+) (*Sweeper, error) {
+	calNet, err := calNetDriver.NewNetworkDriver(client, dockerCli, pools)
+	if err != nil {
+		return nil, err
+	}
+	return &Sweeper{
+		calNetDriver: calNet,
+		calicoIPAM:   calIpamDriver.NewCalicoIPAM(client, pools),
+		dockerCli:    dockerCli,
+		meta:         meta,
+		reserveTTL:   reserveTTL,
+		identity:     identity,
+		suspects:     map[string]bool{},
+	}, nil
+}
+
+// Sweep removes orphaned workload endpoints and host side veths.
+// Orphans are removed when they were also found by the last sweep, or at once when immediate is true,
+// which is only safe before the plugin serves docker.
+// Ips of orphaned endpoints are reserved for fixed-ip containers, and released to calico otherwise.
+func (s *Sweeper) Sweep(ctx context.Context, immediate bool) error {
+	liveEndpoints, liveAddresses, err := s.liveEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+	weps, err := s.calNetDriver.ListEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+	veths, err := s.calNetDriver.ListHostVeths(ctx, weps)
+	if err != nil {
+		return err
+	}
+	liveVeths := liveHostVeths(weps, liveEndpoints, s.calNetDriver.HostVethName)
+
+	found := map[string]bool{}
+	for i := range weps {
+		wep := &weps[i]
+		key := "wep/" + wep.Name
+		if liveEndpoints[wep.Spec.Endpoint] || !s.confirm(key, immediate, found) {
+			continue
+		}
+		log.Warnf("[Sweeper::Sweep] workload endpoint %s of endpoint %s is orphaned, removing", wep.Name, wep.Spec.Endpoint)
+		if err := s.removeEndpoint(ctx, wep, liveAddresses); err != nil {
+			log.Errorf("[Sweeper::Sweep] remove workload endpoint %s error, %v", wep.Name, err)
+			// keep it suspected, so the next sweep retries
+			found[key] = true
+		}
+	}
+	for _, name := range veths {
+		key := "veth/" + name
+		if liveVeths[name] || !s.confirm(key, immediate, found) {
+			continue
+		}
+		log.Warnf("[Sweeper::Sweep] veth %s is orphaned, removing", name)
+		if err := s.calNetDriver.RemoveHostVeth(name); err != nil {
+			log.Errorf("[Sweeper::Sweep] remove veth %s error, %v", name, err)
+			found[key] = true
+		}
+	}
+	s.suspects = found
+	return nil
+}
+
+// RunSweeper sweeps every interval until ctx is done
+func (s *Sweeper) RunSweeper(ctx context.Context, interval time.Duration) {
+	log.Infof("[Sweeper::RunSweeper] sweeper started, interval = %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infoln("[Sweeper::RunSweeper] sweeper stopped")
+			return
+		case <-ticker.C:
+			if err := s.Sweep(ctx, false); err != nil {
+				log.Errorf("[Sweeper::RunSweeper] sweep error, %v", err)
+			}
+		}
+	}
+}
+
+// confirm returns whether the orphan should be removed now, orphans not removed are recorded in found
+func (s *Sweeper) confirm(key string, immediate bool, found map[string]bool) bool {
+	if immediate || s.suspects[key] {
+		return true
+	}
+	found[key] = true
+	return false
+}
+
+// liveEndpoints returns endpoint IDs and addresses which docker knows on this node
+func (s *Sweeper) liveEndpoints(ctx context.Context) (map[string]bool, map[string]bool, error) {
+	containers, err := s.dockerCli.ContainerList(ctx, dockerTypes.ContainerListOptions{All: true})
+	if err != nil {
+		log.Errorf("[Sweeper::liveEndpoints] dockerCli ContainerList error, %v", err)
+		return nil, nil, err
+	}
+	endpoints := map[string]bool{}
+	addresses := map[string]bool{}
+	for _, container := range containers {
+		if container.NetworkSettings == nil {
+			continue
+		}
+		for _, settings := range container.NetworkSettings.Networks {
+			if settings == nil || settings.EndpointID == "" {
+				continue
+			}
+			endpoints[settings.EndpointID] = true
+			for _, address := range []string{settings.IPAddress, settings.GlobalIPv6Address} {
+				if address != "" {
+					addresses[address] = true
+				}
+			}
+		}
+	}
+	return endpoints, addresses, nil
+}
+
+// liveHostVeths returns host side veths of live endpoints by the interface names of their weps,
+// so veths named by an interface prefix used before a restart are kept.
+// Live endpoints without weps yet are named by hostVethName.
+func liveHostVeths(weps []apiv3.WorkloadEndpoint, liveEndpoints map[string]bool, hostVethName func(string) string) map[string]bool {
+	veths := make(map[string]bool, len(liveEndpoints))
+	named := make(map[string]bool, len(liveEndpoints))
+	for _, wep := range weps {
+		if liveEndpoints[wep.Spec.Endpoint] && wep.Spec.InterfaceName != "" {
+			veths[wep.Spec.InterfaceName] = true
+			named[wep.Spec.Endpoint] = true
+		}
+	}
+	for endpointID := range liveEndpoints {
+		if !named[endpointID] {
+			veths[hostVethName(endpointID)] = true
+		}
+	}
+	return veths
+}
+
+// removeEndpoint reserves or releases ips of the orphaned endpoint, then deletes its record and wep.
+// Ips used by live endpoints, e.g. reserved ips acquired by new containers, are left alone.
+func (s *Sweeper) removeEndpoint(ctx context.Context, wep *apiv3.WorkloadEndpoint, liveAddresses map[string]bool) error {
+	endpoint := &types.Endpoint{ID: wep.Spec.Endpoint}
+	found, err := s.meta.GetEndpoint(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	if found && endpoint.ContainerID != "" {
+		if err = reserveEndpointOnLeave(ctx, s.meta, s.identity, s.reserveTTL, endpoint); err != nil {
+			return err
+		}
+	}
+
+	for _, ipNetwork := range wep.Spec.IPNetworks {
+		ip, _, err := net.ParseCIDR(ipNetwork)
+		if err != nil || liveAddresses[ip.String()] {
+			continue
+		}
+		address := &types.ReservedAddress{PoolID: s.poolOf(ctx, endpoint, ip), Address: ip.String()}
+		reserved, err := s.meta.IPIsReserved(ctx, address)
+		if err != nil {
+			return err
+		}
+		if reserved {
+			log.Infof("[Sweeper::removeEndpoint] ip(%s) of orphaned endpoint %s is reserved, keeping it", address.Address, endpoint.ID)
+			continue
+		}
+		if err = s.calicoIPAM.ReleaseIP(ctx, address.PoolID, address.Address); err != nil {
+			return err
+		}
+	}
+
+	if found {
+		if err = s.meta.DeleteEndpoint(ctx, endpoint); err != nil {
+			return err
+		}
+	}
+	return s.calNetDriver.RemoveEndpoint(ctx, wep)
+}
+
+// poolOf returns the pool of ip by the endpoint record, or by pool CIDRs when it's not recorded
+func (s *Sweeper) poolOf(ctx context.Context, endpoint *types.Endpoint, ip net.IP) string {
+	for _, address := range endpoint.Addresses() {
+		if address.Address == ip.String() {
+			return address.PoolID
+		}
+	}
+	pools, err := s.calicoIPAM.IPPools(ctx)
+	if err != nil {
+		log.Errorf("[Sweeper::poolOf] list pools error, %v", err)
+		return ""
+	}
+	for _, pool := range pools.Items {
+		if _, cidr, err := net.ParseCIDR(pool.Spec.CIDR); err == nil && cidr.Contains(ip) {
+			return pool.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	apiv3 "github.com/projectcalico/libcalico-go/lib/apis/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	calNetDriver "github.com/projecteru2/minions/driver/calico/network"
+	"github.com/projecteru2/minions/types"
+)
+
+func TestSweeperConfirm(t *testing.T) {
+	sweeper := &Sweeper{suspects: map[string]bool{}}
+
+	// found for the first time
+	found := map[string]bool{}
+	assert.False(t, sweeper.confirm("wep/w1", false, found))
+	assert.True(t, sweeper.confirm("wep/w2", true, found))
+	assert.Equal(t, map[string]bool{"wep/w1": true}, found)
+	sweeper.suspects = found
+
+	// found again by the next sweep
+	found = map[string]bool{}
+	assert.True(t, sweeper.confirm("wep/w1", false, found))
+	assert.False(t, sweeper.confirm("veth/cali1", false, found))
+	assert.Equal(t, map[string]bool{"veth/cali1": true}, found)
+}
+
+func TestSweeperPoolOfRecordedEndpoint(t *testing.T) {
+	sweeper := &Sweeper{}
+	endpoint := &types.Endpoint{PoolID: "pool", Address: "10.0.0.1", PoolIDIPv6: "pool6", AddressIPv6: "fd00::1"}
+	assert.Equal(t, "pool", sweeper.poolOf(context.Background(), endpoint, net.ParseIP("10.0.0.1")))
+	assert.Equal(t, "pool6", sweeper.poolOf(context.Background(), endpoint, net.ParseIP("fd00::1")))
+}
+
+func TestLiveHostVethsAfterPrefixChange(t *testing.T) {
+	oldNames, err := calNetDriver.NewVethNamer("cali")
+	require.NoError(t, err)
+	newNames, err := calNetDriver.NewVethNamer("tap")
+	require.NoError(t, err)
+	wep := func(endpointID string) apiv3.WorkloadEndpoint {
+		wep := apiv3.NewWorkloadEndpoint()
+		wep.Spec.Endpoint = endpointID
+		wep.Spec.InterfaceName, _ = oldNames(endpointID)
+		return *wep
+	}
+	hostVethName := func(endpointID string) string {
+		name, _ := newNames(endpointID)
+		return name
+	}
+
+	// e1 and e2 joined before the prefix changed, e3 has no wep yet
+	weps := []apiv3.WorkloadEndpoint{wep("e1aaaaaaaaaaaaaa"), wep("e2aaaaaaaaaaaaaa")}
+	live := map[string]bool{"e1aaaaaaaaaaaaaa": true, "e3aaaaaaaaaaaaaa": true}
+	veths := liveHostVeths(weps, live, hostVethName)
+	assert.Equal(t, map[string]bool{"calie1aaaaaaaaa": true, "tape3aaaaaaaaaa": true}, veths)
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/docker/go-plugins-helpers/ipam"
+	"github.com/docker/go-plugins-helpers/network"
+)
+
+const (
+	networkDriver = "network"
+	ipamDriver    = "ipam"
+)
+
+// NetworkDriver records calls of the wrapped network driver
+type NetworkDriver struct {
+	driver network.Driver
+}
+
+// NewNetworkDriver .
+func NewNetworkDriver(driver network.Driver) network.Driver {
+	return NetworkDriver{driver: driver}
+}
+
+// GetCapabilities .
+func (d NetworkDriver) GetCapabilities() (response *network.CapabilitiesResponse, err error) {
+	defer observeDriver(networkDriver, "GetCapabilities", time.Now(), &err)
+	return d.driver.GetCapabilities()
+}
+
+// CreateNetwork .
+func (d NetworkDriver) CreateNetwork(request *network.CreateNetworkRequest) (err error) {
+	defer observeDriver(networkDriver, "CreateNetwork", time.Now(), &err)
+	return d.driver.CreateNetwork(request)
+}
+
+// AllocateNetwork .
+func (d NetworkDriver) AllocateNetwork(request *network.AllocateNetworkRequest) (response *network.AllocateNetworkResponse, err error) {
+	defer observeDriver(networkDriver, "AllocateNetwork", time.Now(), &err)
+	return d.driver.AllocateNetwork(request)
+}
+
+// DeleteNetwork .
+func (d NetworkDriver) DeleteNetwork(request *network.DeleteNetworkRequest) (err error) {
+	defer observeDriver(networkDriver, "DeleteNetwork", time.Now(), &err)
+	return d.driver.DeleteNetwork(request)
+}
+
+// FreeNetwork .
+func (d NetworkDriver) FreeNetwork(request *network.FreeNetworkRequest) (err error) {
+	defer observeDriver(networkDriver, "FreeNetwork", time.Now(), &err)
+	return d.driver.FreeNetwork(request)
+}
+
+// CreateEndpoint .
+func (d NetworkDriver) CreateEndpoint(request *network.CreateEndpointRequest) (response *network.CreateEndpointResponse, err error) {
+	defer observeDriver(networkDriver, "CreateEndpoint", time.Now(), &err)
+	return d.driver.CreateEndpoint(request)
+}
+
+// DeleteEndpoint .
+func (d NetworkDriver) DeleteEndpoint(request *network.DeleteEndpointRequest) (err error) {
+	defer observeDriver(networkDriver, "DeleteEndpoint", time.Now(), &err)
+	return d.driver.DeleteEndpoint(request)
+}
+
+// EndpointInfo .
+func (d NetworkDriver) EndpointInfo(request *network.InfoRequest) (response *network.InfoResponse, err error) {
+	defer observeDriver(networkDriver, "EndpointInfo", time.Now(), &err)
+	return d.driver.EndpointInfo(request)
+}
+
+// Join .
+func (d NetworkDriver) Join(request *network.JoinRequest) (response *network.JoinResponse, err error) {
+	defer observeDriver(networkDriver, "Join", time.Now(), &err)
+	return d.driver.Join(request)
+}
+
+// Leave .
+func (d NetworkDriver) Leave(request *network.LeaveRequest) (err error) {
+	defer observeDriver(networkDriver, "Leave", time.Now(), &err)
+	return d.driver.Leave(request)
+}
+
+// DiscoverNew .
+func (d NetworkDriver) DiscoverNew(request *network.DiscoveryNotification) (err error) {
+	defer observeDriver(networkDriver, "DiscoverNew", time.Now(), &err)
+	return d.driver.DiscoverNew(request)
+}
+
+// DiscoverDelete .
+func (d NetworkDriver) DiscoverDelete(request *network.DiscoveryNotification) (err error) {
+	defer observeDriver(networkDriver, "DiscoverDelete", time.Now(), &err)
+	return d.driver.DiscoverDelete(request)
+}
+
+// ProgramExternalConnectivity .
+func (d NetworkDriver) ProgramExternalConnectivity(request *network.ProgramExternalConnectivityRequest) (err error) {
+	defer observeDriver(networkDriver, "ProgramExternalConnectivity", time.Now(), &err)
+	return d.driver.ProgramExternalConnectivity(request)
+}
+
+// RevokeExternalConnectivity .
+func (d NetworkDriver) RevokeExternalConnectivity(request *network.RevokeExternalConnectivityRequest) (err error) {
+	defer observeDriver(networkDriver, "RevokeExternalConnectivity", time.Now(), &err)
+	return d.driver.RevokeExternalConnectivity(request)
+}
+
+// IPAMDriver records calls of the wrapped ipam driver
+type IPAMDriver struct {
+	driver ipam.Ipam
+}
+
+// NewIPAMDriver .
+func NewIPAMDriver(driver ipam.Ipam) ipam.Ipam {
+	return IPAMDriver{driver: driver}
+}
+
+// GetCapabilities .
+func (d IPAMDriver) GetCapabilities() (response *ipam.CapabilitiesResponse, err error) {
+	defer observeDriver(ipamDriver, "GetCapabilities", time.Now(), &err)
+	return d.driver.GetCapabilities()
+}
+
+// GetDefaultAddressSpaces .
+func (d IPAMDriver) GetDefaultAddressSpaces() (response *ipam.AddressSpacesResponse, err error) {
+	defer observeDriver(ipamDriver, "GetDefaultAddressSpaces", time.Now(), &err)
+	return d.driver.GetDefaultAddressSpaces()
+}
+
+// RequestPool .
+func (d IPAMDriver) RequestPool(request *ipam.RequestPoolRequest) (response *ipam.RequestPoolResponse, err error) {
+	defer observeDriver(ipamDriver, "RequestPool", time.Now(), &err)
+	return d.driver.RequestPool(request)
+}
+
+// ReleasePool .
+func (d IPAMDriver) ReleasePool(request *ipam.ReleasePoolRequest) (err error) {
+	defer observeDriver(ipamDriver, "ReleasePool", time.Now(), &err)
+	return d.driver.ReleasePool(request)
+}
+
+// RequestAddress .
+func (d IPAMDriver) RequestAddress(request *ipam.RequestAddressRequest) (response *ipam.RequestAddressResponse, err error) {
+	defer observeDriver(ipamDriver, "RequestAddress", time.Now(), &err)
+	return d.driver.RequestAddress(request)
+}
+
+// ReleaseAddress .
+func (d IPAMDriver) ReleaseAddress(request *ipam.ReleaseAddressRequest) (err error) {
+	defer observeDriver(ipamDriver, "ReleaseAddress", time.Now(), &err)
+	return d.driver.ReleaseAddress(request)
+}
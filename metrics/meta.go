@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/projecteru2/minions/barrel"
+	"github.com/projecteru2/minions/types"
+)
+
+// Meta records calls of the wrapped barrel meta
+type Meta struct {
+	meta barrel.Meta
+}
+
+// NewMeta .
+func NewMeta(meta barrel.Meta) barrel.Meta {
+	return Meta{meta: meta}
+}
+
+// ReserveIPforContainer .
+func (m Meta) ReserveIPforContainer(ctx context.Context, address *types.ReservedAddress, ID string) (err error) {
+	defer observeMeta("ReserveIPforContainer", time.Now(), &err)
+	return m.meta.ReserveIPforContainer(ctx, address, ID)
+}
+
+// IPIsReserved .
+func (m Meta) IPIsReserved(ctx context.Context, address *types.ReservedAddress) (reserved bool, err error) {
+	defer observeMeta("IPIsReserved", time.Now(), &err)
+	return m.meta.IPIsReserved(ctx, address)
+}
+
+// RequestMarkIsPresent .
+func (m Meta) RequestMarkIsPresent(ctx context.Context, request *types.ReserveRequest) (present bool, err error) {
+	defer observeMeta("RequestMarkIsPresent", time.Now(), &err)
+	return m.meta.RequestMarkIsPresent(ctx, request)
+}
+
+// ConsumeRequestMarkIfPresent .
+func (m Meta) ConsumeRequestMarkIfPresent(ctx context.Context, request *types.ReserveRequest) (consumed bool, err error) {
+	defer observeMeta("ConsumeRequestMarkIfPresent", time.Now(), &err)
+	return m.meta.ConsumeRequestMarkIfPresent(ctx, request)
+}
+
+// AquireIfReserved .
+func (m Meta) AquireIfReserved(ctx context.Context, address *types.ReservedAddress) (acquired bool, err error) {
+	defer observeMeta("AquireIfReserved", time.Now(), &err)
+	return m.meta.AquireIfReserved(ctx, address)
+}
+
+// ListReservedAddresses .
+func (m Meta) ListReservedAddresses(ctx context.Context, poolID string) (addresses []types.ReservedAddress, err error) {
+	defer observeMeta("ListReservedAddresses", time.Now(), &err)
+	return m.meta.ListReservedAddresses(ctx, poolID)
+}
+
+// GetContainerInfo .
+func (m Meta) GetContainerInfo(ctx context.Context, info *types.ContainerInfo) (found bool, err error) {
+	defer observeMeta("GetContainerInfo", time.Now(), &err)
+	return m.meta.GetContainerInfo(ctx, info)
+}
+
+// MarkReserveRequest .
+func (m Meta) MarkReserveRequest(ctx context.Context, request *types.ReserveRequest) (err error) {
+	defer observeMeta("MarkReserveRequest", time.Now(), &err)
+	return m.meta.MarkReserveRequest(ctx, request)
+}
+
+// ListReserveRequests .
+func (m Meta) ListReserveRequests(ctx context.Context, poolID string) (requests []types.ReserveRequest, err error) {
+	defer observeMeta("ListReserveRequests", time.Now(), &err)
+	return m.meta.ListReserveRequests(ctx, poolID)
+}
+
+// ListContainerInfos .
+func (m Meta) ListContainerInfos(ctx context.Context) (infos []types.ContainerInfo, err error) {
+	defer observeMeta("ListContainerInfos", time.Now(), &err)
+	return m.meta.ListContainerInfos(ctx)
+}
+
+// RemoveContainerAddress .
+func (m Meta) RemoveContainerAddress(ctx context.Context, address *types.ReservedAddress) (err error) {
+	defer observeMeta("RemoveContainerAddress", time.Now(), &err)
+	return m.meta.RemoveContainerAddress(ctx, address)
+}
+
+// ReleaseReservedAddress .
+func (m Meta) ReleaseReservedAddress(ctx context.Context, address *types.ReservedAddress) (released bool, err error) {
+	defer observeMeta("ReleaseReservedAddress", time.Now(), &err)
+	return m.meta.ReleaseReservedAddress(ctx, address)
+}
+
+// ReleaseExpiredAddresses .
+func (m Meta) ReleaseExpiredAddresses(ctx context.Context, now time.Time) (released []types.ReservedAddress, err error) {
+	defer observeMeta("ReleaseExpiredAddresses", time.Now(), &err)
+	return m.meta.ReleaseExpiredAddresses(ctx, now)
+}
+
+// PutEndpoint .
+func (m Meta) PutEndpoint(ctx context.Context, endpoint *types.Endpoint) (err error) {
+	defer observeMeta("PutEndpoint", time.Now(), &err)
+	return m.meta.PutEndpoint(ctx, endpoint)
+}
+
+// GetEndpoint .
+func (m Meta) GetEndpoint(ctx context.Context, endpoint *types.Endpoint) (found bool, err error) {
+	defer observeMeta("GetEndpoint", time.Now(), &err)
+	return m.meta.GetEndpoint(ctx, endpoint)
+}
+
+// GetEndpointByAddress .
+func (m Meta) GetEndpointByAddress(ctx context.Context, endpoint *types.Endpoint) (found bool, err error) {
+	defer observeMeta("GetEndpointByAddress", time.Now(), &err)
+	return m.meta.GetEndpointByAddress(ctx, endpoint)
+}
+
+// DeleteEndpoint .
+func (m Meta) DeleteEndpoint(ctx context.Context, endpoint *types.Endpoint) (err error) {
+	defer observeMeta("DeleteEndpoint", time.Now(), &err)
+	return m.meta.DeleteEndpoint(ctx, endpoint)
+}
+
+// PutSubPool .
+func (m Meta) PutSubPool(ctx context.Context, subPool *types.SubPool) (err error) {
+	defer observeMeta("PutSubPool", time.Now(), &err)
+	return m.meta.PutSubPool(ctx, subPool)
+}
+
+// GetSubPool .
+func (m Meta) GetSubPool(ctx context.Context, subPool *types.SubPool) (found bool, err error) {
+	defer observeMeta("GetSubPool", time.Now(), &err)
+	return m.meta.GetSubPool(ctx, subPool)
+}
+
+// DeleteSubPool .
+func (m Meta) DeleteSubPool(ctx context.Context, subPool *types.SubPool) (err error) {
+	defer observeMeta("DeleteSubPool", time.Now(), &err)
+	return m.meta.DeleteSubPool(ctx, subPool)
+}
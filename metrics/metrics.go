@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "minions"
+
+	resultOK    = "ok"
+	resultError = "error"
+)
+
+var (
+	driverCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "driver_calls_total",
+		Help:      "Number of docker plugin driver calls by driver, method and result.",
+	}, []string{"driver", "method", "result"})
+
+	driverDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "driver_call_duration_seconds",
+		Help:      "Latency of docker plugin driver calls by driver and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"driver", "method"})
+
+	metaCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "barrel_calls_total",
+		Help:      "Number of barrel meta calls by method and result.",
+	}, []string{"method", "result"})
+
+	metaDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "barrel_call_duration_seconds",
+		Help:      "Latency of barrel meta calls by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(driverCalls, driverDuration, metaCalls, metaDuration)
+}
+
+// Serve serves metrics on addr, blocks until the listener fails
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux) // nolint:gosec
+}
+
+// observeDriver is deferred with the named error result of the call
+func observeDriver(driver, method string, start time.Time, err *error) {
+	driverCalls.WithLabelValues(driver, method, result(*err)).Inc()
+	driverDuration.WithLabelValues(driver, method).Observe(time.Since(start).Seconds())
+}
+
+// observeMeta is deferred with the named error result of the call
+func observeMeta(method string, start time.Time, err *error) {
+	metaCalls.WithLabelValues(method, result(*err)).Inc()
+	metaDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return resultError
+	}
+	return resultOK
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/projecteru2/minions/barrel/memory"
+	"github.com/projecteru2/minions/types"
+)
+
+func TestMeta(t *testing.T) {
+	ctx := context.Background()
+	meta := NewMeta(memory.NewMemory())
+
+	ok := metaCalls.WithLabelValues("ReserveIPforContainer", resultOK)
+	failed := metaCalls.WithLabelValues("ReserveIPforContainer", resultError)
+	okCount, failedCount := testutil.ToFloat64(ok), testutil.ToFloat64(failed)
+
+	require.NoError(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: "pool", Address: "10.0.0.1"}, "c1"))
+	require.Error(t, meta.ReserveIPforContainer(ctx, &types.ReservedAddress{PoolID: "pool"}, "c1"))
+
+	assert.Equal(t, okCount+1, testutil.ToFloat64(ok))
+	assert.Equal(t, failedCount+1, testutil.ToFloat64(failed))
+}
+
+func TestReservedCollector(t *testing.T) {
+	ctx := context.Background()
+	meta := memory.NewMemory()
+	for _, address := range []types.ReservedAddress{
+		{PoolID: "a", Address: "10.0.0.1"},
+		{PoolID: "a", Address: "10.0.0.2"},
+		{PoolID: "b", Address: "10.1.0.1"},
+	} {
+		address := address
+		require.NoError(t, meta.ReserveIPforContainer(ctx, &address, "c1"))
+	}
+
+	expected := `
+# HELP minions_reserved_addresses Number of reserved addresses by pool.
+# TYPE minions_reserved_addresses gauge
+minions_reserved_addresses{pool="a"} 2
+minions_reserved_addresses{pool="b"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(reservedCollector{meta: meta}, strings.NewReader(expected)))
+}
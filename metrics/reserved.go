@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projecteru2/minions/barrel"
+)
+
+const collectTimeout = 10 * time.Second
+
+var reservedDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "reserved_addresses"),
+	"Number of reserved addresses by pool.",
+	[]string{"pool"}, nil,
+)
+
+// reservedCollector counts reserved addresses from barrel on each scrape,
+// so the gauge is right whichever node reserves or releases addresses
+type reservedCollector struct {
+	meta barrel.Meta
+}
+
+// RegisterReservedCollector registers the gauge of reserved addresses per pool
+func RegisterReservedCollector(meta barrel.Meta) error {
+	return prometheus.Register(reservedCollector{meta: meta})
+}
+
+// Describe .
+func (c reservedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- reservedDesc
+}
+
+// Collect .
+func (c reservedCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	addresses, err := c.meta.ListReservedAddresses(ctx, "")
+	if err != nil {
+		log.Errorf("[Metrics::reservedCollector] list reserved addresses error, %v", err)
+		ch <- prometheus.NewInvalidMetric(reservedDesc, err)
+		return
+	}
+	counts := make(map[string]int)
+	for _, address := range addresses {
+		counts[address.PoolID]++
+	}
+	for pool, count := range counts {
+		ch <- prometheus.MustNewConstMetric(reservedDesc, prometheus.GaugeValue, float64(count), pool)
+	}
+}
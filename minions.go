@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	pluginIPAM "github.com/docker/go-plugins-helpers/ipam"
 	pluginNetwork "github.com/docker/go-plugins-helpers/network"
@@ -12,9 +13,12 @@ import (
 	cli "github.com/urfave/cli/v2"
 
 	dockerClient "github.com/docker/docker/client"
+	"github.com/projecteru2/minions/admin"
 	"github.com/projecteru2/minions/barrel"
 	"github.com/projecteru2/minions/barrel/etcd"
 	"github.com/projecteru2/minions/driver"
+	calDriver "github.com/projecteru2/minions/driver/calico"
+	"github.com/projecteru2/minions/metrics"
 	"github.com/projecteru2/minions/versioninfo"
 	log "github.com/sirupsen/logrus"
 
@@ -30,29 +34,77 @@ func serve(c *cli.Context) error {
 	}
 
 	var (
-		config     *apiconfig.CalicoAPIConfig
 		calicoCli  calicov3.Interface
 		barrelMeta barrel.Meta
 		dockerCli  *dockerClient.Client
 		err        error
 	)
 
-	if config, err = apiconfig.LoadClientConfig(""); err != nil {
+	if calicoCli, barrelMeta, err = newCalicoClients(c); err != nil {
 		return err
 	}
-	if calicoCli, err = calicov3.New(*config); err != nil {
+	if dockerCli, err = dockerClient.NewClientWithOpts(dockerClient.FromEnv); err != nil {
+		return errors.Wrap(err, "Error while attempting to instantiate docker client from env")
+	}
+
+	identity, err := driver.ParseIdentity(c.String("identity"))
+	if err != nil {
 		return err
 	}
-	if barrelMeta, err = etcd.NewEtcdClient(c.Context, *config); err != nil {
+
+	errChannel := make(chan error)
+	metricsAddr := c.String("metrics")
+	if metricsAddr != "" {
+		if err = metrics.RegisterReservedCollector(barrelMeta); err != nil {
+			return err
+		}
+		barrelMeta = metrics.NewMeta(barrelMeta)
+	}
+
+	endpoints := driver.NewEndpointIndex(dockerCli)
+	go endpoints.Run(c.Context)
+
+	pools := calDriver.NewPoolCache(calicoCli, c.Duration("pool-resync"))
+	networkDriver, err := driver.NewNetworkDriver(calicoCli, pools, dockerCli, barrelMeta, c.Duration("reserve-ttl"), identity, endpoints, c.Duration("request-timeout"))
+	if err != nil {
 		return err
 	}
-	if dockerCli, err = dockerClient.NewClientWithOpts(dockerClient.FromEnv); err != nil {
-		return errors.Wrap(err, "Error while attempting to instantiate docker client from env")
+	ipamDriver := driver.NewIPAMDriver(calicoCli, pools, dockerCli, barrelMeta, c.Duration("reserve-ttl"), identity, c.Duration("request-timeout"))
+	if metricsAddr != "" {
+		networkDriver = metrics.NewNetworkDriver(networkDriver)
+		ipamDriver = metrics.NewIPAMDriver(ipamDriver)
+		go func() {
+			log.Infof("minions-metrics has started on %s.", metricsAddr)
+			err := metrics.Serve(metricsAddr)
+			log.Infoln("minions-metrics has stopped working.")
+			errChannel <- err
+		}()
 	}
+	networkHandler := pluginNetwork.NewHandler(networkDriver)
+	ipamHandler := pluginIPAM.NewHandler(ipamDriver)
 
-	errChannel := make(chan error)
-	networkHandler := pluginNetwork.NewHandler(driver.NewNetworkDriver(calicoCli, dockerCli, barrelMeta))
-	ipamHandler := pluginIPAM.NewHandler(driver.NewIPAMDriver(calicoCli, barrelMeta))
+	adm, err := admin.NewAdmin(calicoCli, pools, dockerCli, barrelMeta)
+	if err != nil {
+		return err
+	}
+	if interval := c.Duration("reap-interval"); interval > 0 {
+		go adm.RunReaper(c.Context, interval)
+	}
+	if interval := c.Duration("reconcile-interval"); interval > 0 {
+		go adm.RunReconciler(c.Context, interval, c.Bool("reconcile-fix"))
+	}
+
+	// sweep before serving, so nothing being created is taken as orphans
+	sweeper, err := driver.NewSweeper(calicoCli, pools, dockerCli, barrelMeta, c.Duration("reserve-ttl"), identity)
+	if err != nil {
+		return err
+	}
+	if err = sweeper.Sweep(c.Context, true); err != nil {
+		log.Errorf("sweep orphaned endpoints error, %v", err)
+	}
+	if interval := c.Duration("sweep-interval"); interval > 0 {
+		go sweeper.RunSweeper(c.Context, interval)
+	}
 
 	go func() {
 		log.Infoln("calico-net has started.")
@@ -68,9 +120,38 @@ func serve(c *cli.Context) error {
 		errChannel <- err
 	}()
 
+	if addr := c.String("admin"); addr != "" {
+		// the plugins keep serving docker without the admin api
+		go func() {
+			log.Infoln("minions-admin has started.")
+			err := admin.NewServer(adm).ServeUnix(addr)
+			log.Errorf("minions-admin has stopped working, %v", err)
+		}()
+	}
+
 	return <-errChannel
 }
 
+func newCalicoClients(c *cli.Context) (calicov3.Interface, barrel.Meta, error) {
+	var (
+		config     *apiconfig.CalicoAPIConfig
+		calicoCli  calicov3.Interface
+		barrelMeta barrel.Meta
+		err        error
+	)
+
+	if config, err = apiconfig.LoadClientConfig(""); err != nil {
+		return nil, nil, err
+	}
+	if calicoCli, err = calicov3.New(*config); err != nil {
+		return nil, nil, err
+	}
+	if barrelMeta, err = etcd.NewEtcdClient(c.Context, *config); err != nil {
+		return nil, nil, err
+	}
+	return calicoCli, barrelMeta, nil
+}
+
 func main() {
 	cli.VersionPrinter = func(c *cli.Context) {
 		fmt.Print(versioninfo.VersionString())
@@ -93,6 +174,63 @@ func main() {
 			Usage:   "ipam name",
 			EnvVars: []string{"CALICO_IPAM"},
 		},
+		&cli.StringFlag{
+			Name:    "admin",
+			Value:   admin.DefaultSocket,
+			Usage:   "admin api socket path, a plain name is placed under /run/minions, blank to disable",
+			EnvVars: []string{"CALICO_ADMIN"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics",
+			Usage:   "address to serve prometheus metrics on, e.g. \":9467\", blank to disable",
+			EnvVars: []string{"CALICO_METRICS"},
+		},
+		&cli.DurationFlag{
+			Name:    "reserve-ttl",
+			Usage:   "default ttl of reserved ips, overridden by container label fixed-ip-ttl, 0 means never expire",
+			EnvVars: []string{"CALICO_RESERVE_TTL"},
+		},
+		&cli.StringFlag{
+			Name:    "identity",
+			Value:   "id",
+			Usage:   "key of reserved ips, \"id\", \"name\" or \"label:<label key>\", with a stable one a redeployed fixed-ip container gets its ips back without --ip when it's the only created container waiting for the network",
+			EnvVars: []string{"CALICO_RESERVE_IDENTITY"},
+		},
+		&cli.DurationFlag{
+			Name:    "request-timeout",
+			Value:   30 * time.Second,
+			Usage:   "deadline of calico, barrel and docker calls made for a plugin request, docker fails fast once it passes, 0 to disable",
+			EnvVars: []string{"CALICO_REQUEST_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "pool-resync",
+			Value:   30 * time.Second,
+			Usage:   "interval to reload cached calico pools, pools not in cache are always looked up, 0 to disable cache",
+			EnvVars: []string{"CALICO_POOL_RESYNC"},
+		},
+		&cli.DurationFlag{
+			Name:    "reap-interval",
+			Value:   time.Minute,
+			Usage:   "interval to release expired reserved ips, 0 to disable",
+			EnvVars: []string{"CALICO_REAP_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:    "reconcile-interval",
+			Value:   10 * time.Minute,
+			Usage:   "interval to reconcile barrel, calico IPAM and docker, 0 to disable",
+			EnvVars: []string{"CALICO_RECONCILE_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:    "reconcile-fix",
+			Usage:   "fix drifts found by periodic reconcile instead of only reporting them",
+			EnvVars: []string{"CALICO_RECONCILE_FIX"},
+		},
+		&cli.DurationFlag{
+			Name:    "sweep-interval",
+			Value:   10 * time.Minute,
+			Usage:   "interval to remove veths and workload endpoints of this node orphaned by crashes, 0 to sweep only on start",
+			EnvVars: []string{"CALICO_SWEEP_INTERVAL"},
+		},
 		&cli.BoolFlag{
 			Name:    "debug",
 			Usage:   "debug or not",
@@ -100,6 +238,7 @@ func main() {
 		},
 	}
 	app.Action = serve
+	app.Commands = commands()
 
 	if err := app.Run(os.Args); err != nil {
 		log.Fatalln(err)
@@ -4,5 +4,10 @@ import "github.com/pkg/errors"
 
 var (
 	ErrNoOps         = errors.New("No ops")
+	ErrKeyIsBlank    = errors.New("Key shouldn't be blank")
 	ErrCIDRNotInPool = errors.New("The requested subnet must match the CIDR of a configured Calico IP Pool")
+
+	ErrReservedAddressNotFound = errors.New("Address is not reserved")
+	ErrReserveRequestNotFound  = errors.New("Address is not marked")
+	ErrSubPoolConflict         = errors.New("Pool is already restricted to another sub pool")
 )
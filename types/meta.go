@@ -1,12 +1,24 @@
 package types
 
+import "time"
+
 // ReservedAddress .
 type ReservedAddress struct {
 	PoolID  string
 	Address string
+	// ContainerID is the identity of the container which reserved the address,
+	// the container ID by default
+	ContainerID string
+	// ExpireAt is zero when the reservation never expires
+	ExpireAt time.Time
+}
+
+// Expired .
+func (address ReservedAddress) Expired(now time.Time) bool {
+	return !address.ExpireAt.IsZero() && !now.Before(address.ExpireAt)
 }
 
-// ContainerInfo .
+// ContainerInfo is the record of addresses reserved by a container identity
 type ContainerInfo struct {
 	ID        string
 	Addresses []ReservedAddress
@@ -16,3 +28,37 @@ type ContainerInfo struct {
 type ReserveRequest struct {
 	ReservedAddress
 }
+
+// Endpoint records the owner of a docker endpoint from CreateEndpoint until its address is released,
+// so Leave and ReleaseAddress don't depend on docker still knowing the container
+type Endpoint struct {
+	ID        string
+	NetworkID string
+	// PoolID and Address are of IPv4, PoolIDIPv6 and AddressIPv6 are of IPv6, either pair may be blank
+	PoolID      string
+	Address     string
+	PoolIDIPv6  string
+	AddressIPv6 string
+	// ContainerID, ContainerName and Labels are filled after Join, blank before that
+	ContainerID   string
+	ContainerName string
+	Labels        map[string]string
+}
+
+// Addresses returns the IPv4 and IPv6 addresses of the endpoint which are not blank
+func (endpoint Endpoint) Addresses() []ReservedAddress {
+	var addresses []ReservedAddress
+	if endpoint.Address != "" {
+		addresses = append(addresses, ReservedAddress{PoolID: endpoint.PoolID, Address: endpoint.Address})
+	}
+	if endpoint.AddressIPv6 != "" {
+		addresses = append(addresses, ReservedAddress{PoolID: endpoint.PoolIDIPv6, Address: endpoint.AddressIPv6})
+	}
+	return addresses
+}
+
+// SubPool restricts addresses assigned from the pool to CIDR, it's set by docker --ip-range
+type SubPool struct {
+	PoolID string
+	CIDR   string
+}